@@ -0,0 +1,67 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// Error is returned for calls the master did not accept
+type Error struct {
+	// Call is the type of the rejected call
+	Call string
+	// Status is the HTTP status code of the response
+	Status int
+	// Message is the error text sent by the master
+	Message string
+	// streamID is the Mesos-Stream-Id the call was sent with
+	streamID string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s call failed with status %d", e.Call, e.Status)
+	}
+	return fmt.Sprintf("%s call failed with status %d: %s", e.Call, e.Status, e.Message)
+}
+
+// Temporary reports whether repeating the call may succeed. This is the
+// case when the master failed or is unavailable (5xx), but not for
+// rejected calls.
+func (e *Error) Temporary() bool {
+	return e.Status >= http.StatusInternalServerError
+}
+
+// IsRetryable reports whether err is a transient failure of a call,
+// a network timeout, a connection reset or a temporary error of the
+// master. Errors like invalid URLs or failed TLS handshakes are not
+// retried.
+func IsRetryable(err error) bool {
+	switch e := err.(type) {
+	case *Error:
+		return e.Temporary()
+	case *requestError:
+		return transient(e.err)
+	}
+	return false
+}
+
+// transient reports whether err is a network timeout or a reset connection
+func transient(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET)
+}
+
+// requestError is returned when a call could not be delivered
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string {
+	return fmt.Sprintf("Unable to do request: %s", e.err)
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func urlError(err error) error {
+	return &requestError{&url.Error{Op: "Post", URL: "http://master/api/v1/scheduler", Err: err}}
+}
+
+func TestIsRetryable(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"timeout", urlError(timeoutError{}), true},
+		{"connection reset", urlError(reset), true},
+		{"internal error", &Error{Status: 500}, true},
+		{"unavailable", &Error{Status: 503}, true},
+		{"bad request", &Error{Status: 400}, false},
+		{"redirect", &Error{Status: 307}, false},
+		{"unknown authority", urlError(x509.UnknownAuthorityError{}), false},
+		{"invalid url", urlError(errors.New("unsupported protocol scheme")), false},
+		{"other", errors.New("failed"), false},
+	}
+	for _, test := range tests {
+		if got := IsRetryable(test.err); got != test.retryable {
+			t.Errorf("%s: IsRetryable = %v, expected %v", test.name, got, test.retryable)
+		}
+	}
+}
@@ -2,25 +2,52 @@ package client
 
 import (
 	"bytes"
-	"encoding/json"
-	"fmt"
-	"log"
+	"crypto/tls"
 	"net"
 	"net/http"
+	"sync"
 	"time"
+)
 
-	mesosjson "github.com/bogue1979/mesos-http-scheduler/mesos/json"
+// Media types understood by the Mesos HTTP API
+const (
+	MediaJSON     = "application/json"
+	MediaProtobuf = "application/x-protobuf"
 )
 
 type Client struct {
-	StreamID string
-	url      string
-	client   *http.Client
+	// ContentType is the media type of the payloads sent
+	ContentType string
+	// Accept is the media type requested for responses and events
+	Accept string
+	// Retry is the policy for repeating idempotent calls
+	Retry  RetryPolicy
+	scheme string
+	path   string
+	client *http.Client
+
+	// mu guards the master address and the stream ID which change on
+	// resubscription while calls are sent
+	mu       sync.Mutex
+	addr     string
+	streamID string
+	// streamChanged is closed and replaced when the stream ID changes
+	streamChanged chan struct{}
+
+	principal string
+	secret    string
 }
 
 func New(addr, path string) *Client {
 	return &Client{
-		url: "http://" + addr + path,
+		ContentType: MediaProtobuf,
+		Accept:      MediaJSON,
+		Retry:       DefaultRetryPolicy,
+		scheme:      "http",
+		addr:        addr,
+		path:        path,
+
+		streamChanged: make(chan struct{}),
 		client: &http.Client{
 			Transport: &http.Transport{
 				Dial: (&net.Dialer{
@@ -32,51 +59,111 @@ func New(addr, path string) *Client {
 	}
 }
 
-func (c *Client) Send(payload []byte) (*http.Response, error) {
+// SetCredentials enables HTTP basic authentication of all calls
+// with the framework principal and its secret.
+func (c *Client) SetCredentials(principal, secret string) {
+	c.principal = principal
+	c.secret = secret
+}
 
-	httpReq, err := http.NewRequest("POST", c.url, bytes.NewReader(payload))
-	if err != nil {
-		return nil, err
-	}
+// SetTLS switches the client to HTTPS using cfg. The other settings
+// of the transport are kept.
+func (c *Client) SetTLS(cfg *tls.Config) {
+	c.scheme = "https"
+	t := c.client.Transport.(*http.Transport).Clone()
+	t.TLSClientConfig = cfg
+	c.client.Transport = t
+}
 
-	httpReq.Header.Set("Content-Type", "application/x-protobuf")
-	httpReq.Header.Set("Accept", "application/json")
-	httpReq.Header.Set("User-Agent", "mesos-demo/0.1")
-	if c.StreamID != "" {
-		httpReq.Header.Set("Mesos-Stream-Id", c.StreamID)
-	}
-	//log.Printf("SENDING:%v", httpReq)
+// Reset points the client to a new master address and forgets
+// the stream ID of the previous subscription.
+func (c *Client) Reset(addr string) {
+	c.mu.Lock()
+	c.addr = addr
+	c.setStreamID("")
+	c.mu.Unlock()
+}
 
-	httpResp, err := c.client.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to do request: %s", err)
+// setStreamID changes the stream ID and wakes up waiting calls, c.mu
+// must be held.
+func (c *Client) setStreamID(id string) {
+	if id != c.streamID {
+		c.streamID = id
+		close(c.streamChanged)
+		c.streamChanged = make(chan struct{})
 	}
-	if httpResp.Header.Get("Mesos-Stream-Id") != "" {
-		c.StreamID = httpResp.Header.Get("Mesos-Stream-Id")
-	}
-	return httpResp, nil
 }
 
-func (c *Client) SendAsJson(call *mesosjson.Call) (*http.Response, error) {
-	payload := new(bytes.Buffer)
-	if err := json.NewEncoder(payload).Encode(call); err != nil {
-		return nil, err
+// awaitStream waits up to timeout for a stream ID other than old and
+// the empty one and returns it, the current stream ID on timeout.
+func (c *Client) awaitStream(old string, timeout time.Duration) string {
+	var deadline <-chan time.Time
+	for {
+		c.mu.Lock()
+		id, changed := c.streamID, c.streamChanged
+		c.mu.Unlock()
+		if id != "" && id != old {
+			return id
+		}
+		if deadline == nil {
+			t := time.NewTimer(timeout)
+			defer t.Stop()
+			deadline = t.C
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return id
+		}
 	}
+}
+
+// staleStream reports whether the master rejected a call because it was
+// sent with the stream ID of a previous subscription.
+func (c *Client) staleStream(e *Error) bool {
+	return e.Status == http.StatusBadRequest && e.streamID != "" && e.streamID != c.StreamID()
+}
+
+// StreamID returns the Mesos-Stream-Id of the current subscription
+func (c *Client) StreamID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streamID
+}
 
-	httpReq, err := http.NewRequest("POST", c.url, payload)
+// target returns the URL and the stream ID to send a call with
+func (c *Client) target() (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.scheme + "://" + c.addr + c.path, c.streamID
+}
+
+func (c *Client) Send(payload []byte) (*http.Response, error) {
+	url, streamID := c.target()
+
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(payload))
 	if err != nil {
 		return nil, err
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("Content-Type", c.ContentType)
+	httpReq.Header.Set("Accept", c.Accept)
 	httpReq.Header.Set("User-Agent", "mesos-demo/0.1")
+	if c.principal != "" {
+		httpReq.SetBasicAuth(c.principal, c.secret)
+	}
+	if streamID != "" {
+		httpReq.Header.Set("Mesos-Stream-Id", streamID)
+	}
 
 	httpResp, err := c.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to do request: %s", err)
+		return nil, &requestError{err}
+	}
+	if id := httpResp.Header.Get("Mesos-Stream-Id"); id != "" {
+		c.mu.Lock()
+		c.setStreamID(id)
+		c.mu.Unlock()
 	}
-	c.StreamID = httpResp.Header.Get("Mesos-Stream-Id")
-	log.Println("Stream-ID: ", c.StreamID)
 	return httpResp, nil
 }
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSendWhileReset(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Mesos-Stream-Id") == "" {
+			w.Header().Set("Mesos-Stream-Id", "stream")
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	c := New(addr, "/api/v1/scheduler")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				resp, err := c.Send([]byte("{}"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+	for j := 0; j < 50; j++ {
+		c.Reset(addr)
+	}
+	wg.Wait()
+
+	if id := c.StreamID(); id != "stream" && id != "" {
+		t.Errorf("unexpected stream ID %q", id)
+	}
+}
+
+func TestSetTLS(t *testing.T) {
+	c := New("10.0.0.1:5050", "/api/v1/scheduler")
+	prev := c.client.Transport.(*http.Transport)
+	c.SetTLS(&tls.Config{ServerName: "master"})
+	transport := c.client.Transport.(*http.Transport)
+	if transport.TLSClientConfig.ServerName != "master" || transport.Dial == nil {
+		t.Errorf("transport settings lost: %+v", transport)
+	}
+	if prev.TLSClientConfig != nil && prev.TLSClientConfig.ServerName == "master" {
+		t.Error("previous transport changed")
+	}
+	if url, _ := c.target(); !strings.HasPrefix(url, "https://") {
+		t.Errorf("calls sent to %s", url)
+	}
+}
+
+func TestSetCredentials(t *testing.T) {
+	var mu sync.Mutex
+	var auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auth = append(auth, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	c := New(strings.TrimPrefix(srv.URL, "http://"), "/api/v1/scheduler")
+	for _, principal := range []string{"", "principal"} {
+		c.SetCredentials(principal, "secret")
+		resp, err := c.Send([]byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// base64 of principal:secret
+	want := []string{"", "Basic cHJpbmNpcGFsOnNlY3JldA=="}
+	for i := range want {
+		if auth[i] != want[i] {
+			t.Errorf("call %d sent with authorization %q, expected %q", i, auth[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// MaxRecordSize limits the size of a single RecordIO record.
+const MaxRecordSize = 64 << 20
+
+// maxHeaderSize limits the length header, the digits of a uint64
+const maxHeaderSize = 20
+
+// RecordIOReader reads records framed as <length>\n<payload> like
+// the Mesos master uses for the scheduler event stream.
+type RecordIOReader struct {
+	r *bufio.Reader
+}
+
+// NewRecordIOReader returns a RecordIOReader reading from r
+func NewRecordIOReader(r io.Reader) *RecordIOReader {
+	return &RecordIOReader{r: bufio.NewReader(r)}
+}
+
+// ReadRecord returns the payload of the next complete record.
+// It returns io.EOF when the stream ends cleanly between records and
+// an error describing the problem when the framing is broken.
+func (r *RecordIOReader) ReadRecord() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	switch {
+	case err == bufio.ErrBufferFull || len(line) > maxHeaderSize+1:
+		return nil, fmt.Errorf("recordio: length header exceeds %d bytes", maxHeaderSize)
+	case err == io.EOF && len(line) == 0:
+		return nil, io.EOF
+	case err == io.EOF:
+		return nil, fmt.Errorf("recordio: truncated length header %q", line)
+	case err != nil:
+		return nil, err
+	}
+
+	header := string(line)
+	size, err := strconv.ParseUint(header[:len(header)-1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("recordio: invalid length header %q", header)
+	}
+	if size > MaxRecordSize {
+		return nil, fmt.Errorf("recordio: record of %d bytes exceeds limit of %d bytes", size, MaxRecordSize)
+	}
+
+	record := make([]byte, size)
+	if _, err := io.ReadFull(r.r, record); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("recordio: truncated record, expected %d bytes", size)
+		}
+		return nil, err
+	}
+	return record, nil
+}
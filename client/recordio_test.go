@@ -0,0 +1,47 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadRecord(t *testing.T) {
+	rd := NewRecordIOReader(strings.NewReader("5\nhello0\n3\nabc"))
+	for _, want := range []string{"hello", "", "abc"} {
+		record, err := rd.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(record) != want {
+			t.Errorf("read %q, expected %q", record, want)
+		}
+	}
+	// the stream ends cleanly between records
+	if _, err := rd.ReadRecord(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestReadRecordFraming(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		err    string
+	}{
+		{"truncated header", "12", "truncated length header"},
+		{"truncated record", "10\nhello", "truncated record"},
+		{"non-numeric length", "ten\nhello", "invalid length header"},
+		{"negative length", "-1\nhello", "invalid length header"},
+		{"oversize record", fmt.Sprintf("%d\n", MaxRecordSize+1), "exceeds limit"},
+		{"long header", strings.Repeat("1", 21) + "\n", "length header exceeds"},
+		{"header without newline", strings.Repeat("1", 1<<20), "length header exceeds"},
+	}
+	for _, test := range tests {
+		_, err := NewRecordIOReader(strings.NewReader(test.stream)).ReadRecord()
+		if err == nil || err == io.EOF || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+	}
+}
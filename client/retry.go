@@ -0,0 +1,56 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+)
+
+// RetryPolicy controls how calls that are safe to repeat are retried
+// after transient failures.
+type RetryPolicy struct {
+	// Attempts is the maximal number of attempts, values below 2
+	// disable retries
+	Attempts int
+	// MinBackoff is the wait after the first failed attempt, it
+	// doubles with every further attempt up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used by new clients
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   5,
+	MinBackoff: 200 * time.Millisecond,
+	MaxBackoff: 5 * time.Second,
+}
+
+// backoff returns the wait before the attempt following the failed
+// attempt n (starting at 0) with up to 50% jitter.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.MinBackoff
+	for i := 0; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// idempotent reports whether a call of type t may be sent again
+// without changing its outcome.
+func idempotent(t sched.Call_Type) bool {
+	switch t {
+	case sched.Call_ACKNOWLEDGE,
+		sched.Call_DECLINE,
+		sched.Call_KILL,
+		sched.Call_RECONCILE:
+		return true
+	}
+	return false
+}
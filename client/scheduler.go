@@ -0,0 +1,220 @@
+package client
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	mesosjson "github.com/bogue1979/mesos-http-scheduler/mesos/json"
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+	"github.com/gogo/protobuf/proto"
+)
+
+// maxErrorSize limits how much of an error response is read
+const maxErrorSize = 4096
+
+// Calls are held up to streamWait while the scheduler resubscribes and
+// sent again at most maxStaleResends times when the master rejected
+// them for the stream ID of a previous subscription.
+const (
+	streamWait      = 30 * time.Second
+	maxStaleResends = 3
+)
+
+// Scheduler sends the calls of a subscribed framework to the master
+type Scheduler struct {
+	client *Client
+
+	mu          sync.Mutex
+	frameworkID *mesos.FrameworkID
+}
+
+// NewScheduler returns a Scheduler sending calls with c
+func NewScheduler(c *Client) *Scheduler {
+	return &Scheduler{client: c}
+}
+
+// SetFrameworkID sets the framework ID sent with every call
+func (s *Scheduler) SetFrameworkID(id *mesos.FrameworkID) {
+	s.mu.Lock()
+	s.frameworkID = id
+	s.mu.Unlock()
+}
+
+// FrameworkID returns the framework ID sent with every call
+func (s *Scheduler) FrameworkID() *mesos.FrameworkID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frameworkID
+}
+
+// Call sends call for the framework. The response body is always closed
+// and an *Error is returned unless the master accepted the call.
+// Idempotent calls are retried on transient failures following the
+// retry policy of the client, the error of the last attempt is returned.
+// Calls are held while the scheduler resubscribes and calls rejected for
+// a stale stream ID are sent again with the new one.
+func (s *Scheduler) Call(call *sched.Call) error {
+	s.mu.Lock()
+	call.FrameworkId = s.frameworkID
+	s.mu.Unlock()
+
+	policy := s.client.Retry
+	stale := 0
+	for n := 0; ; {
+		s.client.awaitStream("", streamWait)
+		err := s.call(call)
+		if e, ok := err.(*Error); ok && stale < maxStaleResends && s.client.staleStream(e) {
+			// not processed by the master, safe to send again
+			stale++
+			s.client.awaitStream(e.streamID, streamWait)
+			continue
+		}
+		if err == nil || !IsRetryable(err) || !idempotent(call.GetType()) || n+1 >= policy.Attempts {
+			return err
+		}
+		time.Sleep(policy.backoff(n))
+		n++
+	}
+}
+
+func (s *Scheduler) call(call *sched.Call) error {
+	resp, err := s.client.SendCall(call)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusAccepted {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+	return &Error{
+		Call:     call.GetType().String(),
+		Status:   resp.StatusCode,
+		Message:  strings.TrimSpace(string(msg)),
+		streamID: resp.Request.Header.Get("Mesos-Stream-Id"),
+	}
+}
+
+// SendCall encodes call in the content type of the client and sends it
+func (c *Client) SendCall(call *sched.Call) (*http.Response, error) {
+	var payload []byte
+	var err error
+	if c.ContentType == MediaJSON {
+		payload, err = mesosjson.Marshal(call)
+	} else {
+		payload, err = proto.Marshal(call)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.Send(payload)
+}
+
+// Accept launches operations on offers
+func (s *Scheduler) Accept(offerIDs []*mesos.OfferID, operations []*mesos.Offer_Operation, filters *mesos.Filters) error {
+	return s.Call(&sched.Call{
+		Type: sched.Call_ACCEPT.Enum(),
+		Accept: &sched.Call_Accept{
+			OfferIds:   offerIDs,
+			Operations: operations,
+			Filters:    filters,
+		},
+	})
+}
+
+// Decline declines offers
+func (s *Scheduler) Decline(offerIDs []*mesos.OfferID, filters *mesos.Filters) error {
+	return s.Call(&sched.Call{
+		Type: sched.Call_DECLINE.Enum(),
+		Decline: &sched.Call_Decline{
+			OfferIds: offerIDs,
+			Filters:  filters,
+		},
+	})
+}
+
+// Kill kills a task. agentID and policy are optional.
+func (s *Scheduler) Kill(taskID *mesos.TaskID, agentID *mesos.AgentID, policy *mesos.KillPolicy) error {
+	return s.Call(&sched.Call{
+		Type: sched.Call_KILL.Enum(),
+		Kill: &sched.Call_Kill{
+			TaskId:     taskID,
+			AgentId:    agentID,
+			KillPolicy: policy,
+		},
+	})
+}
+
+// Acknowledge acknowledges a status update
+func (s *Scheduler) Acknowledge(agentID *mesos.AgentID, taskID *mesos.TaskID, uuid []byte) error {
+	return s.Call(&sched.Call{
+		Type: sched.Call_ACKNOWLEDGE.Enum(),
+		Acknowledge: &sched.Call_Acknowledge{
+			AgentId: agentID,
+			TaskId:  taskID,
+			Uuid:    uuid,
+		},
+	})
+}
+
+// Reconcile asks for the state of tasks. No tasks means implicit
+// reconciliation of all tasks known to the master.
+func (s *Scheduler) Reconcile(tasks []*sched.Call_Reconcile_Task) error {
+	return s.Call(&sched.Call{
+		Type:      sched.Call_RECONCILE.Enum(),
+		Reconcile: &sched.Call_Reconcile{Tasks: tasks},
+	})
+}
+
+// Revive removes all offer filters and resumes offers
+func (s *Scheduler) Revive() error {
+	return s.Call(&sched.Call{Type: sched.Call_REVIVE.Enum()})
+}
+
+// Suppress stops offers until the next Revive
+func (s *Scheduler) Suppress() error {
+	return s.Call(&sched.Call{Type: sched.Call_SUPPRESS.Enum()})
+}
+
+// Teardown removes the framework and kills all of its tasks
+func (s *Scheduler) Teardown() error {
+	return s.Call(&sched.Call{Type: sched.Call_TEARDOWN.Enum()})
+}
+
+// Shutdown shuts down an executor
+func (s *Scheduler) Shutdown(executorID *mesos.ExecutorID, agentID *mesos.AgentID) error {
+	return s.Call(&sched.Call{
+		Type: sched.Call_SHUTDOWN.Enum(),
+		Shutdown: &sched.Call_Shutdown{
+			ExecutorId: executorID,
+			AgentId:    agentID,
+		},
+	})
+}
+
+// Message sends data to an executor
+func (s *Scheduler) Message(agentID *mesos.AgentID, executorID *mesos.ExecutorID, data []byte) error {
+	return s.Call(&sched.Call{
+		Type: sched.Call_MESSAGE.Enum(),
+		Message: &sched.Call_Message{
+			AgentId:    agentID,
+			ExecutorId: executorID,
+			Data:       data,
+		},
+	})
+}
+
+// Request asks the allocator for resources
+func (s *Scheduler) Request(requests []*mesos.Request) error {
+	return s.Call(&sched.Call{
+		Type:    sched.Call_REQUEST.Enum(),
+		Request: &sched.Call_Request{Requests: requests},
+	})
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+)
+
+func TestCallResentAfterResubscribe(t *testing.T) {
+	var mu sync.Mutex
+	var streams []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("Mesos-Stream-Id")
+		mu.Lock()
+		streams = append(streams, id)
+		mu.Unlock()
+		if id != "new" {
+			http.Error(w, "The stream ID included in this request didn't match", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	c := New(addr, "/api/v1/scheduler")
+	c.Retry.Attempts = 1
+	c.mu.Lock()
+	c.setStreamID("old")
+	c.mu.Unlock()
+	s := NewScheduler(c)
+
+	// resubscribe while the call is rejected
+	go func() {
+		c.Reset(addr)
+		time.Sleep(50 * time.Millisecond)
+		c.mu.Lock()
+		c.setStreamID("new")
+		c.mu.Unlock()
+	}()
+
+	// ACCEPT is not idempotent, it is only resent because the master
+	// rejected the stale stream ID
+	if err := s.Call(&sched.Call{Type: sched.Call_ACCEPT.Enum()}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if last := streams[len(streams)-1]; last != "new" {
+		t.Errorf("last call sent with stream %q", last)
+	}
+}
+
+func TestCallRejectedForCurrentStream(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "invalid call", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c := New(strings.TrimPrefix(srv.URL, "http://"), "/api/v1/scheduler")
+	c.mu.Lock()
+	c.setStreamID("current")
+	c.mu.Unlock()
+	s := NewScheduler(c)
+
+	err := s.Call(&sched.Call{Type: sched.Call_DECLINE.Enum()})
+	if e, ok := err.(*Error); !ok || e.Status != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("sent %d times", calls)
+	}
+}
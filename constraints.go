@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+)
+
+// hostnameField is the constraint field matching the agent hostname
+const hostnameField = "hostname"
+
+// constraintOp is the operator of a placement constraint
+type constraintOp int
+
+const (
+	// unique places each task on an agent with another value
+	unique constraintOp = iota
+	// cluster places all tasks on agents with the same value
+	cluster
+	// like places tasks on agents with a value matching a regex
+	like
+	// unlike places tasks on agents with a value not matching a regex
+	unlike
+	// groupBy distributes tasks evenly over the values
+	groupBy
+	// maxPer places at most n tasks on agents with the same value
+	maxPer
+)
+
+var constraintOps = map[string]constraintOp{
+	"UNIQUE":   unique,
+	"CLUSTER":  cluster,
+	"LIKE":     like,
+	"UNLIKE":   unlike,
+	"GROUP_BY": groupBy,
+	"MAX_PER":  maxPer,
+}
+
+// constraint limits the agents tasks of the job are placed on by the
+// hostname or an attribute of the agent
+type constraint struct {
+	field string
+	op    constraintOp
+	value string
+	regex *regexp.Regexp
+	n     int
+}
+
+// parseConstraints parses a semicolon separated list of constraints
+// like hostname:UNIQUE;rack:GROUP_BY:3;type:LIKE:m4\..*
+func parseConstraints(list string) ([]constraint, error) {
+	var constraints []constraint
+	for _, item := range strings.Split(list, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		c, err := parseConstraint(item)
+		if err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, c)
+	}
+	return constraints, nil
+}
+
+// parseConstraint parses field:OPERATOR[:value]
+func parseConstraint(s string) (constraint, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 || parts[0] == "" {
+		return constraint{}, fmt.Errorf("invalid constraint %q, expecting field:OPERATOR[:value]", s)
+	}
+	op, ok := constraintOps[parts[1]]
+	if !ok {
+		return constraint{}, fmt.Errorf("unknown operator %s in constraint %q", parts[1], s)
+	}
+	c := constraint{field: parts[0], op: op}
+	if len(parts) == 3 {
+		c.value = parts[2]
+	}
+
+	var err error
+	switch op {
+	case unique:
+		if c.value != "" {
+			return c, fmt.Errorf("constraint %q takes no value", s)
+		}
+	case like, unlike:
+		if c.value == "" {
+			return c, fmt.Errorf("constraint %q needs a regex", s)
+		}
+		if c.regex, err = regexp.Compile("^(?:" + c.value + ")$"); err != nil {
+			return c, fmt.Errorf("invalid regex in constraint %q: %s", s, err)
+		}
+	case groupBy:
+		if c.value != "" {
+			if c.n, err = strconv.Atoi(c.value); err != nil || c.n < 1 {
+				return c, fmt.Errorf("constraint %q needs a positive number of groups", s)
+			}
+		}
+	case maxPer:
+		if c.n, err = strconv.Atoi(c.value); err != nil || c.n < 1 {
+			return c, fmt.Errorf("constraint %q needs a positive maximum", s)
+		}
+	}
+	return c, nil
+}
+
+// satisfied reports whether a task may be placed on an agent with
+// values when tasks are placed on agents with the values in placed.
+// Agents without the field only satisfy UNLIKE.
+func (c constraint) satisfied(values map[string]string, placed []map[string]string) bool {
+	value, ok := values[c.field]
+	if !ok {
+		return c.op == unlike
+	}
+	counts := make(map[string]int)
+	for _, p := range placed {
+		if v, ok := p[c.field]; ok {
+			counts[v]++
+		}
+	}
+
+	switch c.op {
+	case unique:
+		return counts[value] == 0
+	case cluster:
+		if c.value != "" {
+			return value == c.value
+		}
+		// stick to the value of the tasks placed so far
+		return len(counts) == 0 || counts[value] > 0
+	case like:
+		return c.regex.MatchString(value)
+	case unlike:
+		return !c.regex.MatchString(value)
+	case groupBy:
+		// values not used yet count as empty groups, without a number
+		// of groups the groups are the values used so far
+		min := 0
+		if len(counts) > 0 && len(counts) >= c.n {
+			min = -1
+			for _, n := range counts {
+				if min < 0 || n < min {
+					min = n
+				}
+			}
+		}
+		return counts[value] <= min
+	case maxPer:
+		return counts[value] < c.n
+	}
+	return true
+}
+
+// agentValues returns the values constraints are evaluated against,
+// the hostname and the attributes of the agent.
+func agentValues(hostname string, attrs []*mesos.Attribute) map[string]string {
+	values := map[string]string{hostnameField: hostname}
+	for _, attr := range attrs {
+		values[attr.GetName()] = attributeValue(attr)
+	}
+	return values
+}
+
+// attributeValue formats attr like Mesos does, ranges as [1-2,4-8]
+// and sets as {a,b}.
+func attributeValue(attr *mesos.Attribute) string {
+	switch attr.GetType() {
+	case mesos.Value_SCALAR:
+		return strconv.FormatFloat(attr.GetScalar().GetValue(), 'f', -1, 64)
+	case mesos.Value_RANGES:
+		var ranges []string
+		for _, r := range attr.GetRanges().GetRange() {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", r.GetBegin(), r.GetEnd()))
+		}
+		return "[" + strings.Join(ranges, ",") + "]"
+	case mesos.Value_SET:
+		return "{" + strings.Join(attr.GetSet().GetItem(), ",") + "}"
+	}
+	return attr.GetText().GetValue()
+}
+
+// placedValues returns the agent values of the active tasks
+func (s *scheduler) placedValues() []map[string]string {
+	var placed []map[string]string
+	for _, rec := range s.tasks.list() {
+		if !terminal(rec.State) && rec.Hostname != "" {
+			placed = append(placed, agentValues(rec.Hostname, rec.Attributes))
+		}
+	}
+	return placed
+}
+
+// satisfies reports whether a task of the job may be placed on an
+// agent with values
+func (s *scheduler) satisfies(values map[string]string, placed []map[string]string) bool {
+	for _, c := range s.constraints {
+		if !c.satisfied(values, placed) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	"github.com/gogo/protobuf/proto"
+)
+
+func TestConstraintSatisfied(t *testing.T) {
+	rack := func(v string) map[string]string { return map[string]string{"rack": v} }
+	tests := []struct {
+		constraint string
+		placed     []map[string]string
+		agent      map[string]string
+		want       bool
+	}{
+		{"rack:UNIQUE", nil, rack("a"), true},
+		{"rack:UNIQUE", []map[string]string{rack("a")}, rack("a"), false},
+		{"rack:UNIQUE", []map[string]string{rack("a")}, rack("b"), true},
+		{"rack:CLUSTER:a", nil, rack("a"), true},
+		{"rack:CLUSTER:a", nil, rack("b"), false},
+		{"rack:CLUSTER", nil, rack("b"), true},
+		{"rack:CLUSTER", []map[string]string{rack("a")}, rack("b"), false},
+		{"rack:CLUSTER", []map[string]string{rack("a")}, rack("a"), true},
+		{"rack:LIKE:r[12]", nil, rack("r1"), true},
+		{"rack:LIKE:r[12]", nil, rack("r10"), false},
+		{"rack:UNLIKE:r[12]", nil, rack("r3"), true},
+		{"rack:UNLIKE:r[12]", nil, rack("r2"), false},
+		{"rack:GROUP_BY", nil, rack("a"), true},
+		{"rack:GROUP_BY", []map[string]string{rack("a")}, rack("a"), true},
+		{"rack:GROUP_BY", []map[string]string{rack("a"), rack("b")}, rack("a"), true},
+		{"rack:GROUP_BY", []map[string]string{rack("a"), rack("a"), rack("b")}, rack("a"), false},
+		{"rack:GROUP_BY", []map[string]string{rack("a"), rack("a"), rack("b")}, rack("c"), true},
+		{"rack:GROUP_BY:2", []map[string]string{rack("a")}, rack("a"), false},
+		{"rack:GROUP_BY:2", []map[string]string{rack("a")}, rack("b"), true},
+		{"rack:GROUP_BY:2", []map[string]string{rack("a"), rack("b")}, rack("a"), true},
+		{"rack:MAX_PER:2", []map[string]string{rack("a")}, rack("a"), true},
+		{"rack:MAX_PER:2", []map[string]string{rack("a"), rack("a")}, rack("a"), false},
+		// agents without the attribute only satisfy UNLIKE
+		{"rack:UNIQUE", nil, map[string]string{}, false},
+		{"rack:UNLIKE:a", nil, map[string]string{}, true},
+	}
+	for _, test := range tests {
+		c, err := parseConstraint(test.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.satisfied(test.agent, test.placed); got != test.want {
+			t.Errorf("%s with %v placed on %v: got %v, want %v", test.constraint, test.placed, test.agent, got, test.want)
+		}
+	}
+}
+
+func TestParseConstraintsInvalid(t *testing.T) {
+	for _, list := range []string{
+		"rack",
+		"rack:NEAR",
+		"rack:UNIQUE:a",
+		"rack:LIKE",
+		"rack:LIKE:[",
+		"rack:GROUP_BY:x",
+		"rack:MAX_PER",
+		"rack:MAX_PER:0",
+	} {
+		if _, err := parseConstraints(list); err == nil {
+			t.Errorf("%s: expected error", list)
+		}
+	}
+	cs, err := parseConstraints("hostname:UNIQUE; rack:LIKE:r:1;")
+	if err != nil || len(cs) != 2 || cs[1].value != "r:1" {
+		t.Errorf("got %v, %v", cs, err)
+	}
+}
+
+func TestAttributeValue(t *testing.T) {
+	attrs := []*mesos.Attribute{
+		{Name: proto.String("rack"), Type: mesos.Value_TEXT.Enum(), Text: &mesos.Value_Text{Value: proto.String("r1")}},
+		{Name: proto.String("cores"), Type: mesos.Value_SCALAR.Enum(), Scalar: &mesos.Value_Scalar{Value: proto.Float64(2.5)}},
+		{Name: proto.String("ports"), Type: mesos.Value_RANGES.Enum(), Ranges: &mesos.Value_Ranges{Range: []*mesos.Value_Range{
+			{Begin: proto.Uint64(1), End: proto.Uint64(2)},
+			{Begin: proto.Uint64(4), End: proto.Uint64(8)},
+		}}},
+		{Name: proto.String("disks"), Type: mesos.Value_SET.Enum(), Set: &mesos.Value_Set{Item: []string{"ssd", "hdd"}}},
+	}
+	values := agentValues("h1", attrs)
+	want := map[string]string{
+		"hostname": "h1",
+		"rack":     "r1",
+		"cores":    "2.5",
+		"ports":    "[1-2,4-8]",
+		"disks":    "{ssd,hdd}",
+	}
+	for k, v := range want {
+		if values[k] != v {
+			t.Errorf("%s: got %q, want %q", k, values[k], v)
+		}
+	}
+}
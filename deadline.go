@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	"github.com/gogo/protobuf/proto"
+)
+
+// killRetryInterval is the wait before killing a timed out task again
+// which did not terminate yet
+const killRetryInterval = time.Minute
+
+// Reasons a task timed out
+const (
+	timeoutRuntime = "runtime"
+	timeoutStaging = "staging"
+)
+
+// killPolicy returns the kill policy for tasks of the job, nil without
+// a grace period.
+func (s *scheduler) killPolicy() *mesos.KillPolicy {
+	if s.killGrace <= 0 {
+		return nil
+	}
+	return &mesos.KillPolicy{
+		GracePeriod: &mesos.DurationInfo{Nanoseconds: proto.Int64(s.killGrace.Nanoseconds())},
+	}
+}
+
+// checkDeadlines kills tasks running longer than maxRuntime or not
+// running within stagingTimeout after their launch, e.g. staging or
+// starting.
+func (s *scheduler) checkDeadlines(now time.Time) {
+	for _, rec := range s.tasks.list() {
+		if terminal(rec.State) {
+			continue
+		}
+		if rec.TimedOut != "" {
+			if now.Sub(rec.KillSent) > s.killGrace+killRetryInterval {
+				s.kill(rec, rec.TimedOut, now)
+			}
+			continue
+		}
+		switch {
+		case rec.runningSince().IsZero() && s.stagingTimeout > 0 &&
+			now.Sub(rec.Launched) > s.stagingTimeout:
+			log.Println("Task ", rec.ID, " in state ", rec.State.String(), " since launch at ", rec.Launched, ", killing it")
+			s.kill(rec, timeoutStaging, now)
+		case s.maxRuntime > 0 && !rec.runningSince().IsZero() &&
+			now.Sub(rec.runningSince()) > s.maxRuntime:
+			log.Println("Task ", rec.ID, " running since ", rec.runningSince(), ", killing it")
+			s.kill(rec, timeoutRuntime, now)
+		}
+	}
+}
+
+// kill marks rec as timed out and kills it
+func (s *scheduler) kill(rec taskRecord, reason string, now time.Time) {
+	rec = s.tasks.timedOut(rec.ID, reason, now)
+	if err := s.store.SaveTask(rec); err != nil {
+		log.Println("Unable to save task ", rec.ID, ": ", err)
+	}
+	policy := s.killPolicy()
+	s.pool.submit(func() { s.sendKill(rec, policy) })
+}
+
+// sendKill sends the KILL call for the task of rec with policy. It
+// blocks until the call is done and is run outside of the event loop.
+func (s *scheduler) sendKill(rec taskRecord, policy *mesos.KillPolicy) {
+	taskID := &mesos.TaskID{Value: proto.String(rec.ID)}
+	var agentID *mesos.AgentID
+	if rec.AgentID != "" {
+		agentID = &mesos.AgentID{Value: proto.String(rec.AgentID)}
+	}
+	if err := s.callClient.Kill(taskID, agentID, policy); err != nil {
+		log.Println("Unable to send Kill Call: ", err)
+	}
+}
+
+// timeoutOutcome describes a timed out task for its failure details
+func timeoutOutcome(rec taskRecord) string {
+	return fmt.Sprintf("timed out (%s)", rec.TimedOut)
+}
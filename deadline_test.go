@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/fakemaster"
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+)
+
+func TestDeadlines(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s := startScheduler(t, m, func(s *scheduler) {
+		s.stagingTimeout = time.Minute
+		s.maxRuntime = 5 * time.Minute
+		s.killGrace = 30 * time.Second
+	})
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-1", "agent-1", 1)))
+	waitCall(t, m, sched.Call_ACCEPT, 1)
+	tasks := fakemaster.LaunchedTasks(m.Calls())
+	starting, running := tasks[0].GetTaskId().GetValue(), tasks[1].GetTaskId().GetValue()
+
+	send(t, m, fakemaster.UpdateEvent(starting, "agent-1", mesos.TaskState_TASK_STARTING))
+	send(t, m, fakemaster.UpdateEvent(running, "agent-1", mesos.TaskState_TASK_RUNNING))
+	waitCall(t, m, sched.Call_ACKNOWLEDGE, 2)
+
+	// deadlines are checked as if time passed since the launch
+	launched := time.Now()
+	check := func(after time.Duration) {
+		inLoop(s, func() { s.checkDeadlines(launched.Add(after)) })
+	}
+	killed := func(n int) []*sched.Call {
+		t.Helper()
+		kills := waitCall(t, m, sched.Call_KILL, n)
+		time.Sleep(50 * time.Millisecond)
+		if kills, _ = m.WaitCall(sched.Call_KILL, n+1, 0); len(kills) != n {
+			t.Fatalf("sent %d kills, expected %d", len(kills), n)
+		}
+		return kills
+	}
+
+	// a task stuck starting is killed after the staging timeout
+	check(2 * time.Minute)
+	kill := killed(1)[0].GetKill()
+	if kill.GetTaskId().GetValue() != starting {
+		t.Errorf("killed %s, expected the starting task", kill.GetTaskId().GetValue())
+	}
+	if ns := kill.GetKillPolicy().GetGracePeriod().GetNanoseconds(); ns != int64(30*time.Second) {
+		t.Errorf("kill grace period %dns", ns)
+	}
+
+	// no kill again within the grace period and the retry interval
+	check(2*time.Minute + 30*time.Second)
+	killed(1)
+
+	// the starting task is killed again, the running one for its runtime
+	check(6 * time.Minute)
+	kills := killed(3)
+	ids := map[string]bool{}
+	for _, call := range kills[1:] {
+		ids[call.GetKill().GetTaskId().GetValue()] = true
+	}
+	if !ids[starting] || !ids[running] {
+		t.Errorf("killed %v, expected both tasks", ids)
+	}
+
+	// a killed task counts as failed attempt and is retried
+	send(t, m, fakemaster.UpdateEvent(starting, "agent-1", mesos.TaskState_TASK_KILLED))
+	eventually(t, s, "the timed out task is retried", func() bool { return s.retryWaiting == 1 })
+	inLoop(s, func() {
+		rec, _ := s.tasks.get(starting)
+		if rec.TimedOut != timeoutStaging || !strings.Contains(rec.Outcome, "retrying") {
+			t.Errorf("timed out %q, outcome %q", rec.TimedOut, rec.Outcome)
+		}
+	})
+}
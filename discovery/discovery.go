@@ -0,0 +1,120 @@
+// Package discovery finds the leading master of a Mesos cluster.
+package discovery
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Detector finds the address <ip:port> of the leading Mesos master
+type Detector interface {
+	Leader() (string, error)
+}
+
+// Closer is implemented by detectors holding a connection to release
+// once the scheduler is done.
+type Closer interface {
+	Close()
+}
+
+// Static probes a fixed list of masters for the current leader.
+type Static struct {
+	masters []string
+	scheme  string
+	client  *http.Client
+}
+
+// NewStatic returns a Static detector probing masters with the given
+// timeout per master.
+func NewStatic(masters []string, timeout time.Duration) *Static {
+	return &Static{
+		masters: masters,
+		scheme:  "http",
+		client: &http.Client{
+			Timeout: timeout,
+			// the redirect of the master is the answer, do not follow it
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// SetTLS makes the detector talk HTTPS to the masters using cfg. The
+// other settings of the transport are kept.
+func (d *Static) SetTLS(cfg *tls.Config) {
+	d.scheme = "https"
+	transport, ok := d.client.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	transport.TLSClientConfig = cfg
+	d.client.Transport = transport
+}
+
+// Leader asks every configured master in turn for the leader and
+// returns the first answer. Unreachable masters are skipped.
+func (d *Static) Leader() (string, error) {
+	var errs []string
+	for _, master := range d.masters {
+		leader, err := d.probe(master)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return leader, nil
+	}
+	return "", fmt.Errorf("could not find master: %s", strings.Join(errs, "; "))
+}
+
+// probe asks master for the leader using the /master/redirect endpoint.
+// Masters without that endpoint are detected as leader when they answer
+// a GET on the scheduler API with 405.
+func (d *Static) probe(master string) (string, error) {
+	resp, err := d.client.Get(d.scheme + "://" + master + "/master/redirect")
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", master, err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusTemporaryRedirect, http.StatusFound, http.StatusMovedPermanently:
+		return leaderFromLocation(master, resp.Header.Get("Location"))
+	case http.StatusServiceUnavailable:
+		return "", fmt.Errorf("%s: no leading master elected", master)
+	case http.StatusNotFound:
+		return d.probeSchedulerAPI(master)
+	}
+	return "", fmt.Errorf("%s: unexpected redirect status %d", master, resp.StatusCode)
+}
+
+func (d *Static) probeSchedulerAPI(master string) (string, error) {
+	resp, err := d.client.Get(d.scheme + "://" + master + "/api/v1/scheduler")
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", master, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		return "", fmt.Errorf("%s: not the leading master", master)
+	}
+	return master, nil
+}
+
+// leaderFromLocation extracts <host:port> from a redirect location
+// like //10.0.0.1:5050 sent by master.
+func leaderFromLocation(master, location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("%s: invalid redirect location %q", master, location)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return "", fmt.Errorf("%s: invalid redirect location %q", master, location)
+	}
+	return u.Host, nil
+}
@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticSetTLS(t *testing.T) {
+	d := NewStatic([]string{"10.0.0.1:5050"}, time.Second)
+	d.SetTLS(&tls.Config{ServerName: "master"})
+	transport := d.client.Transport.(*http.Transport)
+	if transport.TLSClientConfig.ServerName != "master" || transport.Proxy == nil {
+		t.Errorf("default transport settings lost: %+v", transport)
+	}
+	if cfg := http.DefaultTransport.(*http.Transport).TLSClientConfig; cfg != nil && cfg.ServerName == "master" {
+		t.Error("default transport changed")
+	}
+
+	prev := &http.Transport{MaxIdleConns: 7}
+	d.client.Transport = prev
+	d.SetTLS(&tls.Config{ServerName: "master"})
+	transport = d.client.Transport.(*http.Transport)
+	if transport.MaxIdleConns != 7 || transport.TLSClientConfig.ServerName != "master" {
+		t.Errorf("transport settings lost: %+v", transport)
+	}
+	if prev.TLSClientConfig != nil && prev.TLSClientConfig.ServerName == "master" {
+		t.Error("previous transport changed")
+	}
+}
+
+// testMaster answers the leader probes with handler
+func testMaster(t *testing.T, handler http.HandlerFunc) string {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+// unreachableMaster returns the address of a closed server
+func unreachableMaster() string {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestStaticProbe(t *testing.T) {
+	redirect := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "//10.0.0.2:5050")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	noLeader := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	// masters without /master/redirect answer GET on the scheduler API
+	// with 405 when leading
+	leading := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/scheduler" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	notLeading := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/scheduler" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	unreachable := unreachableMaster()
+	d := NewStatic(nil, time.Second)
+
+	for _, test := range []struct {
+		master string
+		leader string
+		err    string
+	}{
+		{unreachable, "", unreachable},
+		{redirect, "10.0.0.2:5050", ""},
+		{noLeader, "", "no leading master"},
+		{leading, leading, ""},
+		{notLeading, "", "not the leading master"},
+	} {
+		leader, err := d.probe(test.master)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("probe %s: got %q, %v, expected error %q", test.master, leader, err, test.err)
+			}
+			continue
+		}
+		if err != nil || leader != test.leader {
+			t.Errorf("probe %s: got %q, %v, expected %s", test.master, leader, err, test.leader)
+		}
+	}
+}
+
+func TestStaticLeader(t *testing.T) {
+	unreachable := unreachableMaster()
+	redirect := testMaster(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "//10.0.0.2:5050")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+
+	// unreachable masters are skipped
+	d := NewStatic([]string{unreachable, redirect}, time.Second)
+	if leader, err := d.Leader(); err != nil || leader != "10.0.0.2:5050" {
+		t.Errorf("leader %q, %v", leader, err)
+	}
+	d = NewStatic([]string{unreachable}, time.Second)
+	if _, err := d.Leader(); err == nil || !strings.Contains(err.Error(), unreachable) {
+		t.Errorf("expected error naming %s, got %v", unreachable, err)
+	}
+}
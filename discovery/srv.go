@@ -0,0 +1,98 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resolver is the part of *net.Resolver used by SRV, so lookups can be
+// directed to a fake DNS server in tests.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// SRV detects the leading master from DNS SRV records as served by
+// Mesos-DNS, e.g. _leader._tcp.mesos.
+type SRV struct {
+	name     string
+	resolver Resolver
+	timeout  time.Duration
+}
+
+// ParseSRV returns the record name of a srv://<name> URL
+func ParseSRV(srvURL string) (string, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(srvURL, "srv://"), "/")
+	if !strings.HasPrefix(srvURL, "srv://") || name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid srv url %q, expecting srv://<name>", srvURL)
+	}
+	return name, nil
+}
+
+// NewSRV returns a detector looking up the SRV record name with resolver.
+// Each lookup is bounded by timeout.
+func NewSRV(name string, resolver Resolver, timeout time.Duration) *SRV {
+	return &SRV{
+		name:     name,
+		resolver: resolver,
+		timeout:  timeout,
+	}
+}
+
+// DNSResolver returns a resolver sending all queries to the DNS
+// server at addr <ip:port>.
+func DNSResolver(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// Leader returns the target of the preferred SRV record
+func (d *SRV) Leader() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return "", fmt.Errorf("no srv records for %s", d.name)
+	}
+	record := preferred(records)
+	target := strings.TrimSuffix(record.Target, ".")
+	port := strconv.Itoa(int(record.Port))
+
+	if net.ParseIP(target) != nil {
+		return net.JoinHostPort(target, port), nil
+	}
+	addrs, err := d.resolver.LookupHost(ctx, target)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no address for %s", target)
+	}
+	return net.JoinHostPort(addrs[0], port), nil
+}
+
+// preferred returns the record with the lowest priority and among
+// those the highest weight. The order of the lookup is not relied on,
+// *net.Resolver shuffles records of the same priority by weight.
+func preferred(records []*net.SRV) *net.SRV {
+	best := records[0]
+	for _, r := range records[1:] {
+		if r.Priority < best.Priority || r.Priority == best.Priority && r.Weight > best.Weight {
+			best = r
+		}
+	}
+	return best
+}
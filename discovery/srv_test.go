@@ -0,0 +1,104 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers lookups from fixed records
+type fakeResolver struct {
+	srv     []*net.SRV
+	srvErr  error
+	hosts   map[string][]string
+	hostErr error
+	name    string
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.name = name
+	return name, r.srv, r.srvErr
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if r.hostErr != nil {
+		return nil, r.hostErr
+	}
+	return r.hosts[host], nil
+}
+
+func TestSRVLeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		srv    []*net.SRV
+		leader string
+	}{
+		{"ip target", []*net.SRV{
+			{Target: "10.0.0.1.", Port: 5050},
+		}, "10.0.0.1:5050"},
+		{"host target", []*net.SRV{
+			{Target: "master1.mesos.", Port: 5051},
+		}, "10.0.0.11:5051"},
+		{"lowest priority", []*net.SRV{
+			{Target: "10.0.0.2", Port: 5050, Priority: 20, Weight: 100},
+			{Target: "10.0.0.3", Port: 5050, Priority: 10},
+		}, "10.0.0.3:5050"},
+		{"highest weight", []*net.SRV{
+			{Target: "10.0.0.4", Port: 5050, Priority: 10, Weight: 1},
+			{Target: "10.0.0.5", Port: 5050, Priority: 10, Weight: 5},
+			{Target: "10.0.0.6", Port: 5050, Priority: 20, Weight: 9},
+		}, "10.0.0.5:5050"},
+	}
+	for _, test := range tests {
+		r := &fakeResolver{
+			srv:   test.srv,
+			hosts: map[string][]string{"master1.mesos": {"10.0.0.11"}},
+		}
+		leader, err := NewSRV("_leader._tcp.mesos", r, time.Second).Leader()
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if leader != test.leader {
+			t.Errorf("%s: leader %s, expected %s", test.name, leader, test.leader)
+		}
+		if r.name != "_leader._tcp.mesos" {
+			t.Errorf("%s: looked up %s", test.name, r.name)
+		}
+	}
+}
+
+func TestSRVLeaderFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		r    *fakeResolver
+	}{
+		{"lookup error", &fakeResolver{srvErr: errors.New("no such host")}},
+		{"no records", &fakeResolver{}},
+		{"host error", &fakeResolver{
+			srv:     []*net.SRV{{Target: "master1.mesos.", Port: 5050}},
+			hostErr: errors.New("no such host"),
+		}},
+		{"no address", &fakeResolver{
+			srv: []*net.SRV{{Target: "master1.mesos.", Port: 5050}},
+		}},
+	}
+	for _, test := range tests {
+		if leader, err := NewSRV("_leader._tcp.mesos", test.r, time.Second).Leader(); err == nil {
+			t.Errorf("%s: expected an error, got leader %s", test.name, leader)
+		}
+	}
+}
+
+func TestParseSRV(t *testing.T) {
+	if name, err := ParseSRV("srv://_leader._tcp.mesos/"); err != nil || name != "_leader._tcp.mesos" {
+		t.Errorf("ParseSRV = %q, %v", name, err)
+	}
+	for _, url := range []string{"srv://", "zk://host/mesos", "srv://a/b"} {
+		if _, err := ParseSRV(url); err == nil {
+			t.Errorf("expected an error for %s", url)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	"github.com/gogo/protobuf/proto"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Notifier is implemented by detectors that watch the cluster and
+// report every new leader on the returned channel.
+type Notifier interface {
+	Changes() <-chan string
+}
+
+// ZKConn is the part of a ZooKeeper connection used by ZK.
+// It is satisfied by *zk.Conn and by fakes in tests.
+type ZKConn interface {
+	Children(path string) ([]string, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Get(path string) ([]byte, *zk.Stat, error)
+	Close()
+}
+
+// Prefixes of the znodes Mesos masters create for leader election.
+// Newer masters store MasterInfo as JSON, older ones as protobuf.
+const (
+	jsonInfoPrefix  = "json.info_"
+	protoInfoPrefix = "info_"
+)
+
+// ZK detects the leading master from the election znodes in ZooKeeper
+// and watches them for leader changes.
+type ZK struct {
+	conn    ZKConn
+	path    string
+	changes chan string
+	done    chan struct{}
+
+	mu     sync.Mutex
+	leader string
+}
+
+// ParseZK splits a zk://host1:port,host2:port/path URL into the
+// ZooKeeper servers and the znode path.
+func ParseZK(zkURL string) ([]string, string, error) {
+	if !strings.HasPrefix(zkURL, "zk://") {
+		return nil, "", fmt.Errorf("invalid zk url %q", zkURL)
+	}
+	rest := strings.TrimPrefix(zkURL, "zk://")
+	i := strings.Index(rest, "/")
+	if i <= 0 || i == len(rest)-1 {
+		return nil, "", fmt.Errorf("invalid zk url %q, expecting zk://host:port[,host:port..]/path", zkURL)
+	}
+	hosts, path := rest[:i], strings.TrimSuffix(rest[i:], "/")
+	// credentials are not supported, drop them
+	if at := strings.LastIndex(hosts, "@"); at >= 0 {
+		hosts = hosts[at+1:]
+	}
+	return strings.Split(hosts, ","), path, nil
+}
+
+// DialZK connects to the ZooKeeper ensemble of a zk:// URL and returns
+// a detector watching the leader of the Mesos masters.
+func DialZK(zkURL string, timeout time.Duration) (*ZK, error) {
+	servers, path, err := ParseZK(zkURL)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := zk.Connect(servers, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewZK(conn, path), nil
+}
+
+// NewZK returns a detector reading the election znodes below path
+// using conn and starts watching them.
+func NewZK(conn ZKConn, path string) *ZK {
+	d := &ZK{
+		conn:    conn,
+		path:    path,
+		changes: make(chan string, 1),
+		done:    make(chan struct{}),
+	}
+	go d.watch()
+	return d
+}
+
+// Leader looks up the current leader in ZooKeeper
+func (d *ZK) Leader() (string, error) {
+	children, _, err := d.conn.Children(d.path)
+	if err != nil {
+		return "", err
+	}
+	return d.update(children)
+}
+
+// Changes returns a channel receiving the address of each new leader
+func (d *ZK) Changes() <-chan string {
+	return d.changes
+}
+
+// Close stops watching and closes the ZooKeeper connection
+func (d *ZK) Close() {
+	close(d.done)
+	d.conn.Close()
+}
+
+func (d *ZK) watch() {
+	for {
+		children, _, events, err := d.conn.ChildrenW(d.path)
+		if err != nil {
+			log.Println("Unable to watch ", d.path, ": ", err)
+			select {
+			case <-d.done:
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if _, err := d.update(children); err != nil {
+			log.Println("Unable to detect leader: ", err)
+		}
+		select {
+		case <-d.done:
+			return
+		case <-events:
+		}
+	}
+}
+
+// update reads the MasterInfo of the leader among children, remembers
+// it and notifies about changes.
+func (d *ZK) update(children []string) (string, error) {
+	node, ok := leaderNode(children)
+	if !ok {
+		return "", fmt.Errorf("no leading master registered in %s", d.path)
+	}
+	data, _, err := d.conn.Get(d.path + "/" + node)
+	if err != nil {
+		return "", err
+	}
+	info := &mesos.MasterInfo{}
+	if strings.HasPrefix(node, jsonInfoPrefix) {
+		err = json.Unmarshal(data, info)
+	} else {
+		err = proto.Unmarshal(data, info)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid master info in %s: %s", node, err)
+	}
+	leader, err := masterAddress(info)
+	if err != nil {
+		return "", err
+	}
+
+	d.mu.Lock()
+	changed := leader != d.leader
+	d.leader = leader
+	d.mu.Unlock()
+	if changed {
+		// only the latest leader is of interest
+		select {
+		case <-d.changes:
+		default:
+		}
+		d.changes <- leader
+	}
+	return leader, nil
+}
+
+// leaderNode returns the election znode with the lowest sequence number,
+// preferring JSON encoded master info.
+func leaderNode(children []string) (string, bool) {
+	for _, prefix := range []string{jsonInfoPrefix, protoInfoPrefix} {
+		var nodes []string
+		for _, child := range children {
+			if strings.HasPrefix(child, prefix) {
+				nodes = append(nodes, child)
+			}
+		}
+		if len(nodes) > 0 {
+			sort.Strings(nodes)
+			return nodes[0], true
+		}
+	}
+	return "", false
+}
+
+// masterAddress returns <ip:port> of the master described by info
+func masterAddress(info *mesos.MasterInfo) (string, error) {
+	if addr := info.GetAddress(); addr != nil {
+		host := addr.GetIp()
+		if host == "" {
+			host = addr.GetHostname()
+		}
+		if host != "" {
+			return net.JoinHostPort(host, strconv.Itoa(int(addr.GetPort()))), nil
+		}
+	}
+	if pid := info.GetPid(); strings.Contains(pid, "@") {
+		return pid[strings.Index(pid, "@")+1:], nil
+	}
+	if info.Ip != nil {
+		// packed in network order
+		ip := info.GetIp()
+		host := net.IPv4(byte(ip), byte(ip>>8), byte(ip>>16), byte(ip>>24)).String()
+		return net.JoinHostPort(host, strconv.Itoa(int(info.GetPort()))), nil
+	}
+	return "", fmt.Errorf("master info %s has no address", info.GetId())
+}
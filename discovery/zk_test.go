@@ -0,0 +1,212 @@
+package discovery
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	"github.com/gogo/protobuf/proto"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// fakeZK holds the znodes below one path and fires the watch on changes
+type fakeZK struct {
+	mu     sync.Mutex
+	nodes  map[string][]byte
+	watch  chan zk.Event
+	closed bool
+}
+
+func newFakeZK() *fakeZK {
+	return &fakeZK{nodes: make(map[string][]byte)}
+}
+
+func (f *fakeZK) Children(path string) ([]string, *zk.Stat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var children []string
+	for name := range f.nodes {
+		children = append(children, name)
+	}
+	return children, &zk.Stat{}, nil
+}
+
+func (f *fakeZK) ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	children, stat, err := f.Children(path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.watch = make(chan zk.Event, 1)
+	return children, stat, f.watch, err
+}
+
+func (f *fakeZK) Get(path string) ([]byte, *zk.Stat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, data := range f.nodes {
+		if path == "/mesos/"+name {
+			return data, &zk.Stat{}, nil
+		}
+	}
+	return nil, nil, zk.ErrNoNode
+}
+
+func (f *fakeZK) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+}
+
+// set creates or removes (data nil) a znode and fires the watch
+func (f *fakeZK) set(name string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if data == nil {
+		delete(f.nodes, name)
+	} else {
+		f.nodes[name] = data
+	}
+	if f.watch != nil {
+		f.watch <- zk.Event{Type: zk.EventNodeChildrenChanged}
+		f.watch = nil
+	}
+}
+
+func jsonInfo(t *testing.T, ip string, port int32) []byte {
+	info := &mesos.MasterInfo{
+		Id:      proto.String("master"),
+		Ip:      proto.Uint32(0),
+		Port:    proto.Uint32(5050),
+		Address: &mesos.Address{Ip: proto.String(ip), Port: proto.Int32(port)},
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func protoInfo(t *testing.T, info *mesos.MasterInfo) []byte {
+	data, err := proto.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLeaderNode(t *testing.T) {
+	tests := []struct {
+		children []string
+		leader   string
+	}{
+		{[]string{"info_0000000002", "info_0000000001"}, "info_0000000001"},
+		{[]string{"info_0000000001", "json.info_0000000003", "json.info_0000000002"}, "json.info_0000000002"},
+		{[]string{"log_replicas", "info_0000000005"}, "info_0000000005"},
+		{[]string{"log_replicas"}, ""},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		node, ok := leaderNode(test.children)
+		if node != test.leader || ok != (test.leader != "") {
+			t.Errorf("leaderNode(%v) = %q, %v, expected %q", test.children, node, ok, test.leader)
+		}
+	}
+}
+
+func TestZKLeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		node   string
+		data   func(t *testing.T) []byte
+		leader string
+	}{
+		{"json address", "json.info_0000000001", func(t *testing.T) []byte {
+			return jsonInfo(t, "10.0.0.1", 5050)
+		}, "10.0.0.1:5050"},
+		{"protobuf address", "info_0000000001", func(t *testing.T) []byte {
+			return protoInfo(t, &mesos.MasterInfo{
+				Id:   proto.String("master"),
+				Ip:   proto.Uint32(0),
+				Port: proto.Uint32(5050),
+				Address: &mesos.Address{
+					Hostname: proto.String("master1"),
+					Port:     proto.Int32(5051),
+				},
+			})
+		}, "master1:5051"},
+		{"protobuf pid", "info_0000000001", func(t *testing.T) []byte {
+			return protoInfo(t, &mesos.MasterInfo{
+				Id:   proto.String("master"),
+				Ip:   proto.Uint32(0),
+				Port: proto.Uint32(5050),
+				Pid:  proto.String("master@10.0.0.2:5050"),
+			})
+		}, "10.0.0.2:5050"},
+		{"protobuf packed ip", "info_0000000001", func(t *testing.T) []byte {
+			return protoInfo(t, &mesos.MasterInfo{
+				Id:   proto.String("master"),
+				Ip:   proto.Uint32(10 | 3<<24),
+				Port: proto.Uint32(5050),
+			})
+		}, "10.0.0.3:5050"},
+	}
+	for _, test := range tests {
+		conn := newFakeZK()
+		conn.nodes[test.node] = test.data(t)
+		d := NewZK(conn, "/mesos")
+		leader, err := d.Leader()
+		d.Close()
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if leader != test.leader {
+			t.Errorf("%s: leader %s, expected %s", test.name, leader, test.leader)
+		}
+	}
+}
+
+func TestZKLeaderInvalid(t *testing.T) {
+	conn := newFakeZK()
+	d := NewZK(conn, "/mesos")
+	defer d.Close()
+	if _, err := d.Leader(); err == nil {
+		t.Error("expected an error without election znodes")
+	}
+	conn.nodes["json.info_0000000001"] = []byte("{")
+	if _, err := d.Leader(); err == nil {
+		t.Error("expected an error for invalid master info")
+	}
+}
+
+func TestZKChanges(t *testing.T) {
+	conn := newFakeZK()
+	conn.nodes["json.info_0000000001"] = jsonInfo(t, "10.0.0.1", 5050)
+	d := NewZK(conn, "/mesos")
+
+	expect := func(leader string) {
+		t.Helper()
+		select {
+		case got := <-d.Changes():
+			if got != leader {
+				t.Fatalf("leader changed to %s, expected %s", got, leader)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("leader did not change to %s", leader)
+		}
+	}
+	expect("10.0.0.1:5050")
+
+	// the next master in line takes over when the leader is gone
+	conn.set("json.info_0000000002", jsonInfo(t, "10.0.0.2", 5050))
+	conn.set("json.info_0000000001", nil)
+	expect("10.0.0.2:5050")
+
+	d.Close()
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if !conn.closed {
+		t.Error("connection not closed")
+	}
+}
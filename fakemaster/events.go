@@ -0,0 +1,106 @@
+package fakemaster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Offer returns an offer of cpus and mem on agent. The framework ID
+// is filled in by the master when the offer is sent.
+func Offer(id, agent, hostname string, cpus, mem float64) *mesos.Offer {
+	return &mesos.Offer{
+		Id:       &mesos.OfferID{Value: proto.String(id)},
+		AgentId:  &mesos.AgentID{Value: proto.String(agent)},
+		Hostname: proto.String(hostname),
+		Resources: []*mesos.Resource{
+			scalar("cpus", cpus),
+			scalar("mem", mem),
+		},
+	}
+}
+
+func scalar(name string, value float64) *mesos.Resource {
+	return &mesos.Resource{
+		Name:   proto.String(name),
+		Type:   mesos.Value_SCALAR.Enum(),
+		Scalar: &mesos.Value_Scalar{Value: proto.Float64(value)},
+	}
+}
+
+// setFrameworkID sets the framework ID of offers in ev which have none
+func setFrameworkID(ev *sched.Event, id string) {
+	for _, offer := range ev.GetOffers().GetOffers() {
+		if offer.FrameworkId == nil {
+			offer.FrameworkId = &mesos.FrameworkID{Value: proto.String(id)}
+		}
+	}
+}
+
+// OffersEvent returns an OFFERS event
+func OffersEvent(offers ...*mesos.Offer) *sched.Event {
+	return &sched.Event{
+		Type:   sched.Event_OFFERS.Enum(),
+		Offers: &sched.Event_Offers{Offers: offers},
+	}
+}
+
+// RescindEvent returns a RESCIND event for offer id
+func RescindEvent(id string) *sched.Event {
+	return &sched.Event{
+		Type: sched.Event_RESCIND.Enum(),
+		Rescind: &sched.Event_Rescind{
+			OfferId: &mesos.OfferID{Value: proto.String(id)},
+		},
+	}
+}
+
+// UpdateEvent returns an UPDATE event moving task on agent to state.
+// The update carries a UUID and has to be acknowledged.
+func UpdateEvent(task, agent string, state mesos.TaskState) *sched.Event {
+	return &sched.Event{
+		Type: sched.Event_UPDATE.Enum(),
+		Update: &sched.Event_Update{
+			Status: &mesos.TaskStatus{
+				TaskId:    &mesos.TaskID{Value: proto.String(task)},
+				AgentId:   &mesos.AgentID{Value: proto.String(agent)},
+				State:     state.Enum(),
+				Source:    mesos.TaskStatus_SOURCE_EXECUTOR.Enum(),
+				Timestamp: proto.Float64(float64(time.Now().UnixNano()) / 1e9),
+				Uuid:      []byte(fmt.Sprintf("%016d", time.Now().UnixNano()%1e16)),
+			},
+		},
+	}
+}
+
+// HeartbeatEvent returns a HEARTBEAT event
+func HeartbeatEvent() *sched.Event {
+	return &sched.Event{Type: sched.Event_HEARTBEAT.Enum()}
+}
+
+// ErrorEvent returns an ERROR event with message
+func ErrorEvent(message string) *sched.Event {
+	return &sched.Event{
+		Type:  sched.Event_ERROR.Enum(),
+		Error: &sched.Event_Error{Message: proto.String(message)},
+	}
+}
+
+// LaunchedTasks returns the tasks launched by ACCEPT calls
+func LaunchedTasks(calls []*sched.Call) []*mesos.TaskInfo {
+	var tasks []*mesos.TaskInfo
+	for _, call := range calls {
+		if call.GetType() != sched.Call_ACCEPT {
+			continue
+		}
+		for _, op := range call.GetAccept().GetOperations() {
+			if op.GetType() == mesos.Offer_Operation_LAUNCH {
+				tasks = append(tasks, op.GetLaunch().GetTaskInfos()...)
+			}
+		}
+	}
+	return tasks
+}
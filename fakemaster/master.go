@@ -0,0 +1,383 @@
+// Package fakemaster provides an in-process fake Mesos master serving the
+// scheduler HTTP API, so schedulers can be exercised without a cluster.
+//
+// The master answers SUBSCRIBE calls with a RecordIO framed event stream
+// starting with SUBSCRIBED, records every other call and lets the caller
+// push OFFERS, UPDATE, RESCIND, HEARTBEAT or any other event to the
+// subscribed scheduler. LoseLeadership simulates a leader change.
+package fakemaster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	mesosjson "github.com/bogue1979/mesos-http-scheduler/mesos/json"
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	mediaJSON     = "application/json"
+	mediaProtobuf = "application/x-protobuf"
+)
+
+// Master is a fake Mesos master
+type Master struct {
+	server *httptest.Server
+
+	// HeartbeatInterval is announced in the SUBSCRIBED event
+	HeartbeatInterval time.Duration
+
+	mu          sync.Mutex
+	calls       []*sched.Call
+	callAdded   chan struct{}
+	sub         *subscription
+	script      []*sched.Event
+	leader      bool
+	redirect    string
+	frameworkID string
+	streams     int
+	failures    map[sched.Call_Type]int
+}
+
+type subscription struct {
+	streamID string
+	events   chan *sched.Event
+	done     chan struct{}
+}
+
+// New starts a fake master which is the leader
+func New() *Master {
+	m := &Master{
+		HeartbeatInterval: 15 * time.Second,
+		callAdded:         make(chan struct{}),
+		leader:            true,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/scheduler", m.scheduler)
+	mux.HandleFunc("/master/redirect", m.redirectHandler)
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+// Addr returns the address <ip:port> of the master
+func (m *Master) Addr() string {
+	return strings.TrimPrefix(m.server.URL, "http://")
+}
+
+// Close ends the subscription and stops the master
+func (m *Master) Close() {
+	m.mu.Lock()
+	m.endSubscription()
+	m.mu.Unlock()
+	m.server.CloseClientConnections()
+	m.server.Close()
+}
+
+// Script queues events sent to the next subscriber right after SUBSCRIBED
+func (m *Master) Script(events ...*sched.Event) {
+	m.mu.Lock()
+	m.script = append(m.script, events...)
+	m.mu.Unlock()
+}
+
+// Send sends ev to the subscribed scheduler. It fails if no scheduler
+// is subscribed or the scheduler does not read the event within a second.
+func (m *Master) Send(ev *sched.Event) error {
+	m.mu.Lock()
+	sub := m.sub
+	m.mu.Unlock()
+	if sub == nil {
+		return fmt.Errorf("no scheduler subscribed")
+	}
+	select {
+	case sub.events <- ev:
+		return nil
+	case <-sub.done:
+		return fmt.Errorf("subscription closed")
+	case <-time.After(time.Second):
+		return fmt.Errorf("scheduler does not read events")
+	}
+}
+
+// Subscribed reports whether a scheduler is subscribed
+func (m *Master) Subscribed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sub != nil
+}
+
+// Calls returns all calls received so far including SUBSCRIBE
+func (m *Master) Calls() []*sched.Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*sched.Call(nil), m.calls...)
+}
+
+// WaitCall waits until n calls of type t were received and returns them
+func (m *Master) WaitCall(t sched.Call_Type, n int, timeout time.Duration) ([]*sched.Call, error) {
+	deadline := time.After(timeout)
+	for {
+		m.mu.Lock()
+		var found []*sched.Call
+		for _, call := range m.calls {
+			if call.GetType() == t {
+				found = append(found, call)
+			}
+		}
+		added := m.callAdded
+		m.mu.Unlock()
+		if len(found) >= n {
+			return found, nil
+		}
+		select {
+		case <-added:
+		case <-deadline:
+			return found, fmt.Errorf("received %d of %d %s calls", len(found), n, t)
+		}
+	}
+}
+
+// Fail makes the master answer the next n calls of type t with 500
+// Internal Server Error. Failed calls are not recorded.
+func (m *Master) Fail(t sched.Call_Type, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures == nil {
+		m.failures = make(map[sched.Call_Type]int)
+	}
+	m.failures[t] += n
+}
+
+// LoseLeadership ends the subscription and makes the master redirect
+// schedulers to leader. An empty leader means no leader is elected.
+func (m *Master) LoseLeadership(leader string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leader = false
+	m.redirect = leader
+	m.endSubscription()
+}
+
+// BecomeLeader makes the master accept subscriptions again
+func (m *Master) BecomeLeader() {
+	m.mu.Lock()
+	m.leader = true
+	m.redirect = ""
+	m.mu.Unlock()
+}
+
+// Disconnect ends the subscription without a leader change
+func (m *Master) Disconnect() {
+	m.mu.Lock()
+	m.endSubscription()
+	m.mu.Unlock()
+}
+
+// endSubscription closes the current subscription, m.mu must be held
+func (m *Master) endSubscription() {
+	if m.sub != nil {
+		close(m.sub.done)
+		m.sub = nil
+	}
+}
+
+func (m *Master) redirectHandler(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	leader, redirect := m.leader, m.redirect
+	m.mu.Unlock()
+	switch {
+	case leader:
+		redirect = m.Addr()
+	case redirect == "":
+		http.Error(w, "No leader elected", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Location", "//"+redirect)
+	w.WriteHeader(http.StatusTemporaryRedirect)
+}
+
+func (m *Master) scheduler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Expecting 'POST'", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m.mu.Lock()
+	leader, redirect := m.leader, m.redirect
+	m.mu.Unlock()
+	if !leader {
+		if redirect == "" {
+			http.Error(w, "No leader elected", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Location", "//"+redirect+"/api/v1/scheduler")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	call, err := decodeCall(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if call.GetType() == sched.Call_SUBSCRIBE {
+		m.record(call)
+		m.subscribe(w, r, call)
+		return
+	}
+
+	m.mu.Lock()
+	sub := m.sub
+	fail := m.failures[call.GetType()] > 0
+	if fail {
+		m.failures[call.GetType()]--
+	}
+	m.mu.Unlock()
+	if fail {
+		http.Error(w, "Failed by the test", http.StatusInternalServerError)
+		return
+	}
+	if sub == nil || r.Header.Get("Mesos-Stream-Id") != sub.streamID {
+		http.Error(w, "The stream ID included in this request didn't match the stream ID currently associated with framework ID", http.StatusBadRequest)
+		return
+	}
+	if call.GetFrameworkId().GetValue() != m.frameworkIDValue() {
+		http.Error(w, "Call is not from the subscribed framework", http.StatusBadRequest)
+		return
+	}
+	m.record(call)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (m *Master) record(call *sched.Call) {
+	m.mu.Lock()
+	m.calls = append(m.calls, call)
+	close(m.callAdded)
+	m.callAdded = make(chan struct{})
+	m.mu.Unlock()
+}
+
+func (m *Master) frameworkIDValue() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.frameworkID
+}
+
+// subscribe streams events to the subscriber until the subscription or
+// the connection ends.
+func (m *Master) subscribe(w http.ResponseWriter, r *http.Request, call *sched.Call) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	m.mu.Lock()
+	m.endSubscription()
+	m.streams++
+	if id := call.GetSubscribe().GetFrameworkInfo().GetId().GetValue(); id != "" {
+		m.frameworkID = id
+	} else if m.frameworkID == "" {
+		m.frameworkID = fmt.Sprintf("fake-framework-%d", time.Now().UnixNano())
+	}
+	sub := &subscription{
+		streamID: fmt.Sprintf("fake-stream-%d", m.streams),
+		events:   make(chan *sched.Event),
+		done:     make(chan struct{}),
+	}
+	m.sub = sub
+	script := m.script
+	m.script = nil
+	subscribed := &sched.Event{
+		Type: sched.Event_SUBSCRIBED.Enum(),
+		Subscribed: &sched.Event_Subscribed{
+			FrameworkId:              &mesos.FrameworkID{Value: proto.String(m.frameworkID)},
+			HeartbeatIntervalSeconds: proto.Float64(m.HeartbeatInterval.Seconds()),
+		},
+	}
+	m.mu.Unlock()
+
+	accept := mediaJSON
+	if r.Header.Get("Accept") == mediaProtobuf {
+		accept = mediaProtobuf
+	}
+	w.Header().Set("Content-Type", accept)
+	w.Header().Set("Mesos-Stream-Id", sub.streamID)
+	w.WriteHeader(http.StatusOK)
+
+	frameworkID := subscribed.GetSubscribed().GetFrameworkId().GetValue()
+	for _, ev := range append([]*sched.Event{subscribed}, script...) {
+		setFrameworkID(ev, frameworkID)
+		if err := writeEvent(w, accept, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case ev := <-sub.events:
+			setFrameworkID(ev, frameworkID)
+			if err := writeEvent(w, accept, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-sub.done:
+			return
+		case <-r.Context().Done():
+			m.mu.Lock()
+			if m.sub == sub {
+				m.endSubscription()
+			}
+			m.mu.Unlock()
+			return
+		}
+	}
+}
+
+func decodeCall(r *http.Request) (*sched.Call, error) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	call := &sched.Call{}
+	switch r.Header.Get("Content-Type") {
+	case mediaJSON:
+		err = mesosjson.Unmarshal(data, call)
+	case mediaProtobuf:
+		err = proto.Unmarshal(data, call)
+	default:
+		err = fmt.Errorf("unsupported content type %q", r.Header.Get("Content-Type"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// writeEvent writes ev as RecordIO frame encoded as media type accept
+func writeEvent(w http.ResponseWriter, accept string, ev *sched.Event) error {
+	var data []byte
+	var err error
+	if accept == mediaProtobuf {
+		data, err = proto.Marshal(ev)
+	} else {
+		data, err = mesosjson.Marshal(ev)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%d\n", len(data)); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
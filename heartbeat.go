@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// connState tracks the liveness of the current subscription
+type connState struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	lastEvent  time.Time
+	subscribed bool
+	reason     string
+}
+
+// setSubscribed marks the connection as alive and remembers the
+// heartbeat interval announced by the master.
+func (c *connState) setSubscribed(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interval = interval
+	c.lastEvent = time.Now()
+	c.subscribed = true
+	c.reason = ""
+}
+
+// setDisconnected marks the connection as dead with the given reason
+func (c *connState) setDisconnected(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribed {
+		c.subscribed = false
+		c.reason = reason
+	}
+}
+
+// seen records the arrival of an event
+func (c *connState) seen() {
+	c.mu.Lock()
+	c.lastEvent = time.Now()
+	c.mu.Unlock()
+}
+
+// status reports whether the connection is alive and why not
+func (c *connState) status() (bool, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.subscribed && c.reason == "" {
+		return false, "not subscribed"
+	}
+	return c.subscribed, c.reason
+}
+
+// silence returns how long no event was seen and the heartbeat interval
+func (c *connState) silence() (time.Duration, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastEvent.IsZero() {
+		return 0, c.interval
+	}
+	return time.Since(c.lastEvent), c.interval
+}
+
+// watchHeartbeats closes stream when the master missed more than
+// maxMissedHeartbeats heartbeats in a row, which ends qEvents and
+// triggers a resubscription. It returns when done is closed.
+func (s *scheduler) watchHeartbeats(stream io.Closer, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			silence, interval := s.conn.silence()
+			if interval <= 0 || s.maxMissedHeartbeats <= 0 {
+				continue
+			}
+			if silence > time.Duration(s.maxMissedHeartbeats)*interval {
+				reason := fmt.Sprintf("missed %d heartbeats, no event since %s", s.maxMissedHeartbeats, silence)
+				log.Println("Subscription is dead: ", reason)
+				s.conn.setDisconnected(reason)
+				stream.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/fakemaster"
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+)
+
+// healthStatus returns the status code and body of /health
+func healthStatus(s *scheduler) (int, string) {
+	w := httptest.NewRecorder()
+	s.health(w, httptest.NewRequest("GET", "/health", nil))
+	return w.Code, w.Body.String()
+}
+
+func TestMissedHeartbeats(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	m.HeartbeatInterval = 50 * time.Millisecond
+	s := startScheduler(t, m, func(s *scheduler) {
+		s.maxMissedHeartbeats = 2
+	})
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+	if code, body := healthStatus(s); code != http.StatusOK {
+		t.Fatalf("health %d %q while subscribed", code, body)
+	}
+
+	// the master sends no heartbeats, the stream is closed as dead and
+	// the scheduler is unhealthy until it subscribed again
+	m.Fail(sched.Call_SUBSCRIBE, 3)
+	eventually(t, s, "the scheduler is unhealthy", func() bool {
+		code, _ := healthStatus(s)
+		return code == http.StatusServiceUnavailable
+	})
+	if _, body := healthStatus(s); !strings.Contains(body, "missed 2 heartbeats") {
+		t.Errorf("unhealthy for %q", body)
+	}
+	waitCall(t, m, sched.Call_SUBSCRIBE, 2)
+	eventually(t, s, "the scheduler is healthy again", func() bool {
+		code, _ := healthStatus(s)
+		return code == http.StatusOK
+	})
+}
@@ -16,6 +16,21 @@ func root(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "<a href=\"/health\">Found</a>.")
 }
 
-func health(w http.ResponseWriter, r *http.Request) {
+func (s *scheduler) health(w http.ResponseWriter, r *http.Request) {
+	if ok, reason := s.conn.status(); !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "unhealthy: "+reason)
+		return
+	}
 	io.WriteString(w, "healthy")
 }
+
+// resumeHandler resumes a job paused because of failed tasks
+func (s *scheduler) resumeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Expecting 'POST'", http.StatusMethodNotAllowed)
+		return
+	}
+	s.do(s.resume)
+	io.WriteString(w, "resumed")
+}
@@ -1,53 +1,109 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"os/user"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/bogue1979/mesos-http-scheduler/client"
+	"github.com/bogue1979/mesos-http-scheduler/discovery"
 	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
 	"github.com/gogo/protobuf/proto"
 )
 
 var (
-	master      = flag.String("master", "127.0.0.1:5050", "Master addresses <ip:port>[,<ip:port>..]")
-	mesosUser   = flag.String("user", "", "Framework user")
-	maxTasks    = flag.Int("maxtasks", 5, "Maximal concurrent tasks")
-	cmd         = flag.String("cmd", "echo 'Hello World'", "Command to execute")
-	dockerImage = flag.String("img", "", "Docker image to use ")
-	debug       = flag.Bool("debug", false, "Print debug logs")
-	waitTime    = flag.Int64("wait", 60, "Wait in seconds before launching new tasks")
-	cpu         = flag.Float64("cpu", 0.1, "Cpu Resources for one task")
-	mem         = flag.Int("mem", 64, "Memory for one task in MB")
+	master        = flag.String("master", "127.0.0.1:5050", "Master addresses <ip:port>[,<ip:port>..], zk://<host:port>[,<host:port>..]/<path> or srv://<name>")
+	masterTimeout = flag.Duration("master-timeout", 5*time.Second, "Timeout for asking a master or ZooKeeper for the leader")
+	dnsServer     = flag.String("dns-server", "", "DNS server <ip:port> for srv:// masters, default is the system resolver")
+	mesosUser     = flag.String("user", "", "Framework user")
+	maxTasks      = flag.Int("maxtasks", 5, "Maximal concurrent tasks")
+	cmd           = flag.String("cmd", "echo 'Hello World'", "Command to execute")
+	dockerImage   = flag.String("img", "", "Docker image to use ")
+	debug         = flag.Bool("debug", false, "Print debug logs")
+	waitTime      = flag.Int64("wait", 60, "Wait in seconds before launching new tasks, 0 launches them only once")
+	cpu           = flag.Float64("cpu", 0.1, "Cpu Resources for one task")
+	mem           = flag.Int("mem", 64, "Memory for one task in MB")
+	minBackoff    = flag.Duration("reconnect-wait", time.Second, "Initial wait before reconnecting to the master")
+	maxBackoff    = flag.Duration("reconnect-max-wait", 30*time.Second, "Maximal wait between reconnect attempts")
+	maxMissed     = flag.Int("max-missed-heartbeats", 5, "Missed heartbeats before resubscribing, 0 disables the check")
+	failover      = flag.Duration("failover-timeout", 0, "Time the master waits for a failed over scheduler before killing its tasks")
+	checkpoint    = flag.Bool("checkpoint", false, "Let agents checkpoint tasks so they survive agent restarts")
+	stateFile     = flag.String("state-file", "", "File to persist framework ID, tasks and runs for failover")
+	principal     = flag.String("principal", "", "Principal to authenticate the framework with")
+	secretFile    = flag.String("secret-file", "", "File containing the secret of the principal")
+	callRetries   = flag.Int("call-retries", client.DefaultRetryPolicy.Attempts, "Attempts for acknowledge, decline, kill and reconcile calls")
+	reconcileIntv = flag.Duration("reconcile-interval", 15*time.Minute, "Time between task reconciliations, 0 reconciles only after subscribing")
+	maxAttempts   = flag.Int("max-attempts", 3, "Launches of a failed task including the first one")
+	retryWait     = flag.Duration("retry-wait", 10*time.Second, "Wait before launching a failed task again, doubles with every attempt")
+	retryMaxWait  = flag.Duration("retry-max-wait", 5*time.Minute, "Maximal wait before launching a failed task again")
+	retryStates   = flag.String("retry-states", "TASK_FAILED,TASK_LOST", "Comma separated task states to retry")
+	retryReasons  = flag.String("retry-reasons", "", "Comma separated reasons to retry, default are all reasons")
+	onExhausted   = flag.String("on-exhausted", "giveup", "Action when a task failed for good <giveup|pause|exit>")
+	maxRuntime    = flag.Duration("max-runtime", 0, "Kill tasks running longer, 0 disables the limit")
+	stagingTime   = flag.Duration("staging-timeout", 0, "Kill tasks not running this long after launch, e.g. staging or starting, 0 disables the limit")
+	killGrace     = flag.Duration("kill-grace", 30*time.Second, "Grace period for tasks to terminate when killed")
+	callWorkers   = flag.Int("call-workers", 4, "Concurrent calls to the master")
+	useTLS        = flag.Bool("tls", false, "Use HTTPS to talk to the masters")
+	caFile        = flag.String("ca-file", "", "PEM file of CAs to verify the masters with, default are the system CAs")
+	certFile      = flag.String("cert-file", "", "PEM client certificate for mutual TLS")
+	keyFile       = flag.String("key-file", "", "PEM client key for mutual TLS")
+	tlsInsecure   = flag.Bool("tls-insecure-skip-verify", false, "Do not verify the master certificates (testing only)")
+	callFormat    = flag.String("content-type", "protobuf", "Wire format of calls to the master <json|protobuf>")
+	eventFormat   = flag.String("event-format", "json", "Wire format of the event stream <json|protobuf>")
+	shutdownWait  = flag.Duration("shutdown-wait", 0, "Time to wait for active tasks to end on SIGTERM or SIGINT")
+	shutdownKill  = flag.Bool("shutdown-kill", false, "Kill tasks still active after -shutdown-wait before disconnecting")
+	onShutdown    = flag.String("on-shutdown", "failover", "Action on SIGTERM or SIGINT <failover|teardown>")
+	strategy      = flag.String("placement", "binpack", "Placement of tasks on offered agents <binpack|spread>")
+	constraints   = flag.String("constraints", "", "Semicolon separated placement constraints <field>:<UNIQUE|CLUSTER|LIKE|UNLIKE|GROUP_BY|MAX_PER>[:<value>]")
 )
 
-func init() {
-	flag.Parse()
+// mediaType returns the media type of a wire format name
+func mediaType(format string) (string, error) {
+	switch format {
+	case "json":
+		return client.MediaJSON, nil
+	case "protobuf":
+		return client.MediaProtobuf, nil
+	}
+	return "", fmt.Errorf("unknown format %s", format)
 }
 
-func findMesosMaster(masters string) (string, error) {
-	masterservers := strings.Split(masters, ",")
-
-	for _, master := range masterservers {
-
-		resp, err := http.Get("http://" + master + "/api/v1/scheduler")
+// newDetector returns the master detector for the -master flag.
+// tlsConfig is used to talk to the masters if not nil.
+func newDetector(masters string, tlsConfig *tls.Config) (discovery.Detector, error) {
+	if strings.HasPrefix(masters, "zk://") {
+		return discovery.DialZK(masters, *masterTimeout)
+	}
+	if strings.HasPrefix(masters, "srv://") {
+		name, err := discovery.ParseSRV(masters)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode == 405 {
-			fmt.Println("Current Master is ", master)
-			return master, nil
+		resolver := net.DefaultResolver
+		if *dnsServer != "" {
+			resolver = discovery.DNSResolver(*dnsServer)
 		}
+		return discovery.NewSRV(name, resolver, *masterTimeout), nil
+	}
+	d := discovery.NewStatic(strings.Split(masters, ","), *masterTimeout)
+	if tlsConfig != nil {
+		d.SetTLS(tlsConfig)
 	}
-	return "", fmt.Errorf("could not find master")
+	return d, nil
 }
 
 func main() {
+	flag.Parse()
 
 	if *mesosUser == "" {
 		u, err := user.Current()
@@ -62,11 +118,86 @@ func main() {
 		os.Exit(1)
 	}
 
-	mmaster, err := findMesosMaster(*master)
+	if *waitTime < 0 {
+		fmt.Println("wait must not be negative")
+		os.Exit(1)
+	}
+
+	accept, err := mediaType(*eventFormat)
+	if err != nil {
+		fmt.Println("unknown event format ", *eventFormat)
+		os.Exit(1)
+	}
+	contentType, err := mediaType(*callFormat)
+	if err != nil {
+		fmt.Println("unknown content type ", *callFormat)
+		os.Exit(1)
+	}
+
+	var secret string
+	if *secretFile != "" {
+		if *principal == "" {
+			fmt.Println("secret file given without principal")
+			os.Exit(1)
+		}
+		data, err := ioutil.ReadFile(*secretFile)
+		if err != nil {
+			log.Fatal("Unable to read secret file: ", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+
+	if !*useTLS && (*caFile != "" || *certFile != "" || *keyFile != "" || *tlsInsecure) {
+		fmt.Println("TLS options given without -tls")
+		os.Exit(1)
+	}
+	var tlsConfig *tls.Config
+	if *useTLS {
+		var err error
+		tlsConfig, err = client.TLSConfig(*caFile, *certFile, *keyFile, *tlsInsecure)
+		if err != nil {
+			log.Fatal("Unable to setup TLS: ", err)
+		}
+	}
+
+	detector, err := newDetector(*master, tlsConfig)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	mmaster, err := detector.Leader()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	fmt.Println("Current Master is ", mmaster)
+
+	retry := retryPolicy{
+		maxAttempts: *maxAttempts,
+		minBackoff:  *retryWait,
+		maxBackoff:  *retryMaxWait,
+	}
+	if retry.states, err = parseTaskStates(*retryStates); err != nil {
+		log.Fatal(err)
+	}
+	if retry.reasons, err = parseReasons(*retryReasons); err != nil {
+		log.Fatal(err)
+	}
+	if retry.exhausted, err = parseExhaustAction(*onExhausted); err != nil {
+		log.Fatal(err)
+	}
+	shutdownAction, err := parseShutdownAction(*onShutdown)
+	if err != nil {
+		log.Fatal(err)
+	}
+	place, err := parsePlacement(*strategy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cons, err := parseConstraints(*constraints)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -74,21 +205,89 @@ func main() {
 	}
 
 	fw := &mesos.FrameworkInfo{
-		User:     mesosUser,
-		Name:     proto.String("Go-HTTP Scheduler"),
-		Hostname: proto.String(hostname),
+		User:            mesosUser,
+		Name:            proto.String("Go-HTTP Scheduler"),
+		Hostname:        proto.String(hostname),
+		FailoverTimeout: proto.Float64(failover.Seconds()),
+		Checkpoint:      proto.Bool(*checkpoint),
+	}
+	if *principal != "" {
+		fw.Principal = principal
+	}
+	var store stateStore = newMemStore()
+	if *stateFile != "" {
+		if store, err = openFileStore(*stateFile); err != nil {
+			log.Fatal("Unable to open state file: ", err)
+		}
+	}
+	st, err := store.Load()
+	if err != nil {
+		log.Fatal("Unable to load state: ", err)
+	}
+	switch {
+	case st.FrameworkID != "" && *failover <= 0:
+		// the master removed the framework when we disconnected
+		log.Println("Not failing over to framework ", st.FrameworkID, " without failover timeout")
+	case st.FrameworkID != "":
+		log.Println("Failing over to framework ", st.FrameworkID)
+		fw.Id = &mesos.FrameworkID{Value: proto.String(st.FrameworkID)}
 	}
 	cmdInfo := &mesos.CommandInfo{
 		Shell: proto.Bool(true),
 		Value: proto.String(*cmd),
 	}
 
+	sched := newSched(mmaster, fw, cmdInfo, float64(*mem), *cpu, *waitTime)
+	sched.maxTasks = *maxTasks
+	sched.client.Accept = accept
+	sched.client.ContentType = contentType
+	sched.client.SetCredentials(*principal, secret)
+	sched.client.Retry.Attempts = *callRetries
+	if tlsConfig != nil {
+		sched.client.SetTLS(tlsConfig)
+	}
+	sched.detector = detector
+	sched.minBackoff = *minBackoff
+	sched.maxBackoff = *maxBackoff
+	sched.maxMissedHeartbeats = *maxMissed
+	sched.store = store
+	sched.restore(st)
+	sched.callWorkers = *callWorkers
+	sched.reconcileInterval = *reconcileIntv
+	sched.retry = retry
+	sched.maxRuntime = *maxRuntime
+	sched.stagingTimeout = *stagingTime
+	sched.killGrace = *killGrace
+	sched.shutdownWait = *shutdownWait
+	sched.shutdownKill = *shutdownKill
+	sched.shutdownAction = shutdownAction
+	sched.placement = place
+	sched.constraints = cons
+
 	// http health endpoint for marathon ;-)
 	http.HandleFunc("/", root)
-	http.HandleFunc("/health", health)
+	http.HandleFunc("/health", sched.health)
+	http.HandleFunc("/resume", sched.resumeHandler)
 	go http.ListenAndServe(":8080", nil)
 
-	sched := newSched(mmaster, fw, cmdInfo, float64(*mem), *cpu, *waitTime)
-	sched.maxTasks = *maxTasks
-	<-sched.start()
+	// shut down gracefully on the first signal, exit on the second. The
+	// handler is installed before subscribing so no signal is missed.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	done := sched.start()
+	go func() {
+		sig := <-signals
+		log.Println("Received ", sig)
+		sched.shutdown()
+		sig = <-signals
+		log.Fatal("Received ", sig, " again, exiting")
+	}()
+
+	<-done
+	if c, ok := detector.(discovery.Closer); ok {
+		c.Close()
+	}
+	if sched.exitErr != nil {
+		log.Fatal(sched.exitErr)
+	}
 }
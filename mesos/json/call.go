@@ -1,18 +1,111 @@
+// Package json encodes calls and events of the Mesos HTTP API as JSON.
 package json
 
-type Call struct {
-	Type      string     `json:"type"`
-	Subscribe *Subscribe `json:"subscribe"`
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+)
+
+var (
+	marshaler   = jsonpb.Marshaler{OrigName: true}
+	unmarshaler = jsonpb.Unmarshaler{AllowUnknownFields: true}
+)
+
+// Marshal encodes msg, usually a scheduler call, as JSON with the
+// original field names of the protobuf definition and enums by name
+// as the Mesos master expects them. 64 bit integers are numbers, not
+// strings like in the protobuf JSON mapping, so every master version
+// accepts them.
+func Marshal(msg proto.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := marshaler.Marshal(&buf, msg); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(&buf)
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	numbers(v, reflect.TypeOf(msg))
+	return json.Marshal(v)
+}
+
+// numbers replaces the strings of the 64 bit integer fields in the
+// JSON object v of a message of the generated type t with numbers. The
+// fields are found by the original names in their protobuf tags.
+func numbers(v interface{}, t reflect.Type) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	t = elem(t)
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := tagName(field.Tag.Get("protobuf"))
+		value, ok := obj[name]
+		if name == "" || !ok || field.Type.Kind() == reflect.Map {
+			continue
+		}
+		switch ft := elem(field.Type); ft.Kind() {
+		case reflect.Int64, reflect.Uint64:
+			if list, ok := value.([]interface{}); ok {
+				for i, item := range list {
+					list[i] = number(item)
+				}
+			} else {
+				obj[name] = number(value)
+			}
+		case reflect.Struct:
+			if list, ok := value.([]interface{}); ok {
+				for _, item := range list {
+					numbers(item, ft)
+				}
+			} else {
+				numbers(value, ft)
+			}
+		}
+	}
 }
-type Subscribe struct {
-	FrameworkInfo *FrameworkInfo `json:"framework_info"`
+
+// elem returns the type of the values of a pointer or slice field
+func elem(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) {
+		t = t.Elem()
+	}
+	return t
 }
-type FrameworkInfo struct {
-	User         string `json:"user"`
-	Name         string `json:"name"`
-	HostName     string `json:"hostname"`
-	*FrameworkID `json:"framework_id"`
+
+// tagName returns the original field name of a protobuf struct tag
+// like "varint,1,opt,name=nanoseconds", "" for other fields
+func tagName(tag string) string {
+	for _, part := range strings.Split(tag, ",") {
+		if strings.HasPrefix(part, "name=") {
+			return strings.TrimPrefix(part, "name=")
+		}
+	}
+	return ""
 }
-type FrameworkID struct {
-	Value string `json:"value"`
+
+// number returns the string s as number
+func number(s interface{}) interface{} {
+	if str, ok := s.(string); ok {
+		return json.Number(str)
+	}
+	return s
+}
+
+// Unmarshal decodes JSON encoded by Marshal or by the Mesos master into
+// msg. 64 bit integers may be numbers or strings, fields unknown to
+// msg, e.g. sent by newer masters, are ignored.
+func Unmarshal(data []byte, msg proto.Message) error {
+	return unmarshaler.Unmarshal(bytes.NewReader(data), msg)
 }
@@ -0,0 +1,92 @@
+package json
+
+import (
+	"testing"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	"github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+	"github.com/gogo/protobuf/proto"
+)
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		call *sched.Call
+		json string
+	}{
+		{"kill policy", &sched.Call{
+			FrameworkId: &mesos.FrameworkID{Value: proto.String("framework")},
+			Type:        sched.Call_KILL.Enum(),
+			Kill: &sched.Call_Kill{
+				TaskId:  &mesos.TaskID{Value: proto.String("task")},
+				AgentId: &mesos.AgentID{Value: proto.String("agent")},
+				KillPolicy: &mesos.KillPolicy{
+					GracePeriod: &mesos.DurationInfo{Nanoseconds: proto.Int64(30000000000)},
+				},
+			},
+		}, `{"framework_id":{"value":"framework"},` +
+			`"kill":{"agent_id":{"value":"agent"},"kill_policy":{"grace_period":{"nanoseconds":30000000000}},"task_id":{"value":"task"}},` +
+			`"type":"KILL"}`},
+		{"port ranges", &sched.Call{
+			FrameworkId: &mesos.FrameworkID{Value: proto.String("framework")},
+			Type:        sched.Call_ACCEPT.Enum(),
+			Accept: &sched.Call_Accept{
+				OfferIds: []*mesos.OfferID{{Value: proto.String("offer")}},
+				Operations: []*mesos.Offer_Operation{{
+					Type: mesos.Offer_Operation_LAUNCH.Enum(),
+					Launch: &mesos.Offer_Operation_Launch{
+						TaskInfos: []*mesos.TaskInfo{{
+							Name:    proto.String("task"),
+							TaskId:  &mesos.TaskID{Value: proto.String("task")},
+							AgentId: &mesos.AgentID{Value: proto.String("agent")},
+							Resources: []*mesos.Resource{{
+								Name: proto.String("ports"),
+								Type: mesos.Value_RANGES.Enum(),
+								Ranges: &mesos.Value_Ranges{Range: []*mesos.Value_Range{
+									{Begin: proto.Uint64(31000), End: proto.Uint64(31001)},
+								}},
+							}},
+						}},
+					},
+				}},
+				Filters: &mesos.Filters{RefuseSeconds: proto.Float64(5)},
+			},
+		}, `{"accept":{"filters":{"refuse_seconds":5},"offer_ids":[{"value":"offer"}],` +
+			`"operations":[{"launch":{"task_infos":[{"agent_id":{"value":"agent"},"name":"task",` +
+			`"resources":[{"name":"ports","ranges":{"range":[{"begin":31000,"end":31001}]},"type":"RANGES"}],` +
+			`"task_id":{"value":"task"}}]},"type":"LAUNCH"}]},` +
+			`"framework_id":{"value":"framework"},"type":"ACCEPT"}`},
+	}
+	for _, test := range tests {
+		data, err := Marshal(test.call)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if string(data) != test.json {
+			t.Errorf("%s: got\n%s\nexpected\n%s", test.name, data, test.json)
+		}
+
+		call := &sched.Call{}
+		if err := Unmarshal(data, call); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !proto.Equal(call, test.call) {
+			t.Errorf("%s: round trip yields %s", test.name, call)
+		}
+	}
+}
+
+func TestUnmarshalStrings(t *testing.T) {
+	// the protobuf JSON mapping encodes 64 bit integers as strings
+	call := &sched.Call{}
+	err := Unmarshal([]byte(`{"type":"KILL","kill":{"task_id":{"value":"task"},`+
+		`"kill_policy":{"grace_period":{"nanoseconds":"30000000000"}}},"unknown":1}`), call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns := call.GetKill().GetKillPolicy().GetGracePeriod().GetNanoseconds(); ns != 30000000000 {
+		t.Errorf("grace period %d", ns)
+	}
+}
@@ -3,112 +3,212 @@ package main
 import (
 	"fmt"
 	"log"
-	"net/http"
 	"time"
 
 	mesos "github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
-	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
 	"github.com/gogo/protobuf/proto"
 )
 
-// Offers handle incoming offers
+// Offers handle incoming offers. Tasks are placed on all offers of
+// the event together, the offers of an agent are accepted with one
+// call and unused offers are declined with one call.
 func (s *scheduler) offers(offers []*mesos.Offer) {
-	for _, offer := range offers {
-		debugLog(fmt.Sprintln("Processing offer ", offer.Id.GetValue()))
+	running := make(map[string]int)
+	for _, rec := range s.tasks.list() {
+		if !terminal(rec.State) {
+			running[rec.AgentID]++
+		}
+	}
+	agents := s.groupOffers(offers, running)
+	placed := s.placedValues()
 
-		cpus, mems := s.offeredResources(offer)
-		var tasks []*mesos.TaskInfo
-		debugLog(fmt.Sprintln("cpus available for tasks: ", cpus, " mems available: ", mems))
-		call := &sched.Call{}
-		if s.acceptNew == false {
-			call = &sched.Call{
-				FrameworkId: s.framework.GetId(),
-				Type:        sched.Call_DECLINE.Enum(),
-				Decline: &sched.Call_Decline{
-					OfferIds: []*mesos.OfferID{
-						offer.GetId(),
-					},
-					//Filters: &mesos.Filters{RefuseSeconds: proto.Float64(1)},
-				},
-			}
-		} else {
-			for s.taskLaunched < s.maxTasks && cpus >= s.cpuPerTask && mems >= s.memPerTask {
+	runChanged := false
+	for s.canLaunch() {
+		a := s.pick(agents, placed)
+		if a == nil {
+			break
+		}
+		attempt := 1
+		if len(s.retries) > 0 {
+			attempt = s.retries[0]
+			s.retries = s.retries[1:]
+		}
+		task := s.newTask(a.agentID, attempt)
+		a.tasks = append(a.tasks, task)
+		a.running++
+		placed = append(placed, a.values)
+		a.cpus -= s.cpuPerTask
+		a.mems -= s.memPerTask
+		s.taskLaunched(task, a.offers[0], attempt)
+		if attempt == 1 {
+			s.addToRun(task)
+			runChanged = true
+		}
 
-				container := &mesos.ContainerInfo{
-					Type: mesos.ContainerInfo_DOCKER.Enum(),
-					Docker: &mesos.ContainerInfo_DockerInfo{
-						Image:          proto.String(*dockerImage),
-						Network:        mesos.ContainerInfo_DockerInfo_BRIDGE.Enum(),
-						ForcePullImage: proto.Bool(true),
-					},
-				}
+		if s.tasks.active() == s.maxTasks {
+			s.acceptNew = false
+		}
+	}
+	if runChanged {
+		s.saveRun()
+	}
+	if !s.acceptNew {
+		// the run is complete, the next one starts with new work
+		s.currentRun = nil
+	}
 
-				taskID := fmt.Sprintf("%d", time.Now().UnixNano())
-				debugLog(fmt.Sprintln("Preparing task with id ", taskID, " for launch"))
-				task := &mesos.TaskInfo{
-					Name: proto.String(fmt.Sprintf("task-%s", taskID)),
-					TaskId: &mesos.TaskID{
-						Value: proto.String(taskID),
-					},
-					AgentId: offer.AgentId,
-					Resources: []*mesos.Resource{
-						&mesos.Resource{
-							Name:   proto.String("cpus"),
-							Type:   mesos.Value_SCALAR.Enum(),
-							Scalar: &mesos.Value_Scalar{Value: proto.Float64(s.cpuPerTask)},
-						},
-						&mesos.Resource{
-							Name:   proto.String("mem"),
-							Type:   mesos.Value_SCALAR.Enum(),
-							Scalar: &mesos.Value_Scalar{Value: proto.Float64(s.memPerTask)},
-						},
-					},
-					Command:   s.command,
-					Container: container,
-				}
-				tasks = append(tasks, task)
-				s.taskLaunched++
-				cpus -= s.cpuPerTask
-				mems -= s.memPerTask
+	// keep the remaining resources for others when we are done
+	var filters *mesos.Filters
+	if !s.canLaunch() {
+		filters = s.refuseFilters(time.Now())
+	}
+	var unused []*mesos.OfferID
+	for _, a := range agents {
+		debugLog(fmt.Sprintln("Agent ", a.agentID.GetValue(), " offers ", len(a.offers), " placing ", len(a.tasks), " tasks"))
+		if len(a.tasks) == 0 {
+			unused = append(unused, a.offerIDs()...)
+			continue
+		}
+		s.accept(a, filters)
+	}
+	if len(unused) > 0 {
+		s.pool.submit(func() {
+			if err := s.callClient.Decline(unused, filters); err != nil {
+				log.Println("Unable to send Decline Call: ", err)
+			}
+		})
+	}
+}
 
-				if s.taskLaunched == s.maxTasks {
-					s.acceptNew = false
+// accept launches the tasks placed on the offers of a
+func (s *scheduler) accept(a *agentOffers, filters *mesos.Filters) {
+	operations := []*mesos.Offer_Operation{
+		&mesos.Offer_Operation{
+			Type: mesos.Offer_Operation_LAUNCH.Enum(),
+			Launch: &mesos.Offer_Operation_Launch{
+				TaskInfos: a.tasks,
+			},
+		},
+	}
+	offerIDs := a.offerIDs()
+	tasks := a.tasks
+	launch := &pendingLaunch{tasks: tasks}
+	for _, id := range offerIDs {
+		launch.offers = append(launch.offers, id.GetValue())
+		s.launches[id.GetValue()] = launch
+	}
+	s.pool.submit(func() {
+		if !launch.claim() {
+			// offer rescinded meanwhile
+			return
+		}
+		err := s.callClient.Accept(offerIDs, operations, filters)
+		s.do(func() {
+			for _, id := range launch.offers {
+				delete(s.launches, id)
+			}
+			if err != nil {
+				log.Println("Unable to send Accept Call: ", err)
+				for _, task := range tasks {
+					s.forgetTask(task.GetTaskId().GetValue())
 				}
 			}
+		})
+	})
+}
 
-			// setup launch call
-			call = &sched.Call{
-				FrameworkId: s.framework.GetId(),
-				Type:        sched.Call_ACCEPT.Enum(),
-				Accept: &sched.Call_Accept{
-					OfferIds: []*mesos.OfferID{
-						offer.GetId(),
-					},
-					Operations: []*mesos.Offer_Operation{
-						&mesos.Offer_Operation{
-							Type: mesos.Offer_Operation_LAUNCH.Enum(),
-							Launch: &mesos.Offer_Operation_Launch{
-								TaskInfos: tasks,
-							},
-						},
-					},
-					//Filters: &mesos.Filters{RefuseSeconds: proto.Float64(1)},
-				},
-			}
-		}
+// newTask returns attempt of a task of the job on agentID
+func (s *scheduler) newTask(agentID *mesos.AgentID, attempt int) *mesos.TaskInfo {
+	container := &mesos.ContainerInfo{
+		Type: mesos.ContainerInfo_DOCKER.Enum(),
+		Docker: &mesos.ContainerInfo_DockerInfo{
+			Image:          proto.String(*dockerImage),
+			Network:        mesos.ContainerInfo_DockerInfo_BRIDGE.Enum(),
+			ForcePullImage: proto.Bool(true),
+		},
+	}
 
-		// send call
-		resp, err := s.send(call)
-		if err != nil {
-			log.Println("Unable to send Accept Call: ", err)
-			continue
-		}
-		if resp.StatusCode != http.StatusAccepted {
-			log.Printf("Accept Call returned unexpected status: %d", resp.StatusCode)
+	taskID := fmt.Sprintf("%d", time.Now().UnixNano())
+	debugLog(fmt.Sprintln("Preparing task with id ", taskID, " attempt ", attempt, " for launch"))
+	return &mesos.TaskInfo{
+		Name: proto.String(fmt.Sprintf("task-%s", taskID)),
+		TaskId: &mesos.TaskID{
+			Value: proto.String(taskID),
+		},
+		AgentId: agentID,
+		Resources: []*mesos.Resource{
+			&mesos.Resource{
+				Name:   proto.String("cpus"),
+				Type:   mesos.Value_SCALAR.Enum(),
+				Scalar: &mesos.Value_Scalar{Value: proto.Float64(s.cpuPerTask)},
+			},
+			&mesos.Resource{
+				Name:   proto.String("mem"),
+				Type:   mesos.Value_SCALAR.Enum(),
+				Scalar: &mesos.Value_Scalar{Value: proto.Float64(s.memPerTask)},
+			},
+		},
+		Command:    s.command,
+		Container:  container,
+		KillPolicy: s.killPolicy(),
+	}
+}
+
+// canLaunch reports whether a task should be launched. Retries of
+// failed tasks continue the current run, new tasks are only launched
+// when accepting new work and no slot is reserved for a retry. Nothing
+// is launched while shutting down or paused, pending retries wait for
+// the job to be resumed.
+func (s *scheduler) canLaunch() bool {
+	if s.draining || s.paused {
+		return false
+	}
+	active := s.tasks.active()
+	if len(s.retries) > 0 {
+		return active < s.maxTasks
+	}
+	return s.acceptNew && active+s.retryWaiting < s.maxTasks
+}
+
+// taskLaunched records attempt of a task about to be launched on offer
+func (s *scheduler) taskLaunched(task *mesos.TaskInfo, offer *mesos.Offer, attempt int) {
+	rec, pruned := s.tasks.launched(task, offer, attempt)
+	if err := s.store.SaveTask(rec); err != nil {
+		log.Println("Unable to save task ", rec.ID, ": ", err)
+	}
+	for _, id := range pruned {
+		if err := s.store.DeleteTask(id); err != nil {
+			log.Println("Unable to delete task ", id, ": ", err)
 		}
 	}
 }
 
+// forgetTask drops a task which could not be launched
+func (s *scheduler) forgetTask(id string) {
+	s.tasks.remove(id)
+	if err := s.store.DeleteTask(id); err != nil {
+		log.Println("Unable to delete task ", id, ": ", err)
+	}
+}
+
+// addToRun adds the first attempt of task to the current job run,
+// starting a run if there is none
+func (s *scheduler) addToRun(task *mesos.TaskInfo) {
+	if s.currentRun == nil {
+		s.currentRun = &jobRun{Time: time.Now()}
+	}
+	s.currentRun.Tasks = append(s.currentRun.Tasks, task.GetTaskId().GetValue())
+}
+
+// saveRun records the current job run in the run history
+func (s *scheduler) saveRun() {
+	run := *s.currentRun
+	run.Tasks = append([]string(nil), s.currentRun.Tasks...)
+	if err := s.store.SaveRun(run); err != nil {
+		log.Println("Unable to save job run: ", err)
+	}
+}
+
 // offeredResources
 func (s *scheduler) offeredResources(offer *mesos.Offer) (cpus, mems float64) {
 	for _, res := range offer.GetResources() {
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+)
+
+// placement is the strategy to choose the agent for a task
+type placement int
+
+const (
+	// binpack fills agents before using others to leave whole agents free
+	binpack placement = iota
+	// spread distributes tasks over as many agents as possible
+	spread
+)
+
+// parsePlacement parses binpack or spread
+func parsePlacement(s string) (placement, error) {
+	switch s {
+	case "binpack":
+		return binpack, nil
+	case "spread":
+		return spread, nil
+	}
+	return binpack, fmt.Errorf("unknown placement %q, expecting binpack or spread", s)
+}
+
+// agentOffers are the offers of one agent with their unused resources
+// and the tasks placed on them
+type agentOffers struct {
+	agentID *mesos.AgentID
+	offers  []*mesos.Offer
+	cpus    float64
+	mems    float64
+	// running counts the active tasks on the agent including placed ones
+	running int
+	tasks   []*mesos.TaskInfo
+	// values are the hostname and attributes for constraints
+	values map[string]string
+}
+
+// groupOffers merges offers by agent keeping the order of the offers.
+// running counts the active tasks by agent ID.
+func (s *scheduler) groupOffers(offers []*mesos.Offer, running map[string]int) []*agentOffers {
+	var agents []*agentOffers
+	byID := make(map[string]*agentOffers)
+	for _, offer := range offers {
+		id := offer.GetAgentId().GetValue()
+		a, ok := byID[id]
+		if !ok {
+			a = &agentOffers{
+				agentID: offer.GetAgentId(),
+				running: running[id],
+				values:  agentValues(offer.GetHostname(), offer.GetAttributes()),
+			}
+			byID[id] = a
+			agents = append(agents, a)
+		}
+		cpus, mems := s.offeredResources(offer)
+		a.offers = append(a.offers, offer)
+		a.cpus += cpus
+		a.mems += mems
+	}
+	return agents
+}
+
+// offerIDs returns the IDs of the offers of a
+func (a *agentOffers) offerIDs() []*mesos.OfferID {
+	ids := make([]*mesos.OfferID, 0, len(a.offers))
+	for _, offer := range a.offers {
+		ids = append(ids, offer.GetId())
+	}
+	return ids
+}
+
+// pick returns the agent to place the next task on following the
+// placement strategy, nil if no agent has enough resources left or
+// satisfies the constraints given the tasks on agents with placed values.
+func (s *scheduler) pick(agents []*agentOffers, placed []map[string]string) *agentOffers {
+	var best *agentOffers
+	for _, a := range agents {
+		if a.cpus < s.cpuPerTask || a.mems < s.memPerTask {
+			continue
+		}
+		if !s.satisfies(a.values, placed) {
+			continue
+		}
+		if best == nil || s.placement.better(a, best) {
+			best = a
+		}
+	}
+	return best
+}
+
+// better reports whether a is preferred over b
+func (p placement) better(a, b *agentOffers) bool {
+	if p == spread {
+		if a.running != b.running {
+			return a.running < b.running
+		}
+		// most resources left
+		if a.cpus != b.cpus {
+			return a.cpus > b.cpus
+		}
+		return a.mems > b.mems
+	}
+	// least resources left
+	if a.cpus != b.cpus {
+		return a.cpus < b.cpus
+	}
+	return a.mems < b.mems
+}
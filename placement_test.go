@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	"github.com/gogo/protobuf/proto"
+)
+
+// placeAll picks agents for tasks until none fits, like the offer
+// handling, and returns the agent IDs in the order picked
+func placeAll(s *scheduler, agents []*agentOffers) []string {
+	var picked []string
+	for {
+		a := s.pick(agents, nil)
+		if a == nil {
+			return picked
+		}
+		picked = append(picked, a.agentID.GetValue())
+		a.running++
+		a.cpus -= s.cpuPerTask
+		a.mems -= s.memPerTask
+	}
+}
+
+func TestPick(t *testing.T) {
+	agents := func() []*agentOffers {
+		agent := func(id string, cpus, mems float64, running int) *agentOffers {
+			return &agentOffers{
+				agentID: &mesos.AgentID{Value: proto.String(id)},
+				cpus:    cpus,
+				mems:    mems,
+				running: running,
+			}
+		}
+		return []*agentOffers{
+			agent("large", 3, 512, 0),
+			agent("small", 1, 512, 0),
+			agent("busy", 2, 512, 2),
+			agent("low-mem", 2, 128, 0),
+		}
+	}
+	for _, test := range []struct {
+		placement placement
+		want      []string
+	}{
+		// the fullest agent first, on a tie the one with less memory
+		{binpack, []string{"small", "low-mem", "low-mem", "busy", "busy", "large", "large", "large"}},
+		// the agent with the fewest tasks, on a tie the most resources
+		{spread, []string{"large", "low-mem", "small", "large", "low-mem", "busy", "large", "busy"}},
+	} {
+		s := &scheduler{placement: test.placement, cpuPerTask: 1, memPerTask: 64}
+		if got := placeAll(s, agents()); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("placement %d picked %v, expected %v", test.placement, got, test.want)
+		}
+	}
+}
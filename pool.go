@@ -0,0 +1,63 @@
+package main
+
+import "sync"
+
+// workerPool runs calls to the master on a bounded number of
+// goroutines so the event loop never waits for the network.
+//
+// The queue is unbounded: submit never blocks, otherwise the event loop
+// could wait for workers which themselves wait in do for the loop.
+type workerPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   []func()
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// newWorkerPool starts a pool of n workers
+func newWorkerPool(n int) *workerPool {
+	p := &workerPool{}
+	p.cond = sync.NewCond(&p.mu)
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for len(p.jobs) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.jobs) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		job := p.jobs[0]
+		p.jobs[0] = nil
+		p.jobs = p.jobs[1:]
+		p.mu.Unlock()
+		job()
+	}
+}
+
+// submit queues job
+func (p *workerPool) submit(job func()) {
+	p.mu.Lock()
+	p.jobs = append(p.jobs, job)
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+// close stops the workers and waits until all queued jobs are done
+func (p *workerPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+	p.wg.Wait()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolSubmitDoesNotBlock(t *testing.T) {
+	p := newWorkerPool(1)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(5001)
+	p.submit(func() {
+		<-release
+		wg.Done()
+	})
+
+	submitted := make(chan struct{})
+	go func() {
+		for i := 0; i < 5000; i++ {
+			p.submit(wg.Done)
+		}
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("submit blocked while the worker is busy")
+	}
+
+	close(release)
+	p.close()
+	wg.Wait()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Bounds of the wait before asking again for tasks without update
+const (
+	reconcileMinBackoff = 5 * time.Second
+	reconcileMaxBackoff = 5 * time.Minute
+)
+
+// reconcile asks the master for the state of all active tasks in the
+// registry, retrying until every task got an update, followed by
+// implicit reconciliation of tasks the registry does not know.
+func (s *scheduler) reconcile() {
+	s.reconcilePending = make(map[string]string)
+	for _, rec := range s.tasks.list() {
+		if !terminal(rec.State) {
+			s.reconcilePending[rec.ID] = rec.AgentID
+		}
+	}
+	s.reconcileBackoff = reconcileMinBackoff
+	debugLog(fmt.Sprintln("Reconciling ", len(s.reconcilePending), " tasks"))
+	s.sendReconcile()
+
+	s.pool.submit(func() {
+		if err := s.callClient.Reconcile(nil); err != nil {
+			log.Println("Unable to send implicit Reconcile Call: ", err)
+		}
+	})
+}
+
+// sendReconcile sends explicit reconciliation for all pending tasks
+// and schedules a retry.
+func (s *scheduler) sendReconcile() {
+	if len(s.reconcilePending) == 0 {
+		return
+	}
+	var tasks []*sched.Call_Reconcile_Task
+	for id, agent := range s.reconcilePending {
+		task := &sched.Call_Reconcile_Task{TaskId: &mesos.TaskID{Value: proto.String(id)}}
+		if agent != "" {
+			task.AgentId = &mesos.AgentID{Value: proto.String(agent)}
+		}
+		tasks = append(tasks, task)
+	}
+	s.pool.submit(func() {
+		if err := s.callClient.Reconcile(tasks); err != nil {
+			log.Println("Unable to send Reconcile Call: ", err)
+		}
+	})
+
+	if !s.reconcileScheduled {
+		s.reconcileScheduled = true
+		time.AfterFunc(s.reconcileBackoff, func() { s.do(s.retryReconcile) })
+	}
+}
+
+// retryReconcile asks again for tasks which got no update yet
+func (s *scheduler) retryReconcile() {
+	s.reconcileScheduled = false
+	if len(s.reconcilePending) == 0 {
+		return
+	}
+	if s.reconcileBackoff *= 2; s.reconcileBackoff > reconcileMaxBackoff {
+		s.reconcileBackoff = reconcileMaxBackoff
+	}
+	debugLog(fmt.Sprintln("Retrying reconciliation of ", len(s.reconcilePending), " tasks"))
+	s.sendReconcile()
+}
+
+// reconciled marks the task of status as reconciled
+func (s *scheduler) reconciled(status *mesos.TaskStatus) {
+	delete(s.reconcilePending, status.GetTaskId().GetValue())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	"github.com/gogo/protobuf/proto"
+)
+
+// pendingLaunch is an ACCEPT call waiting in the worker pool. Either
+// the worker sends it or a RESCIND of one of its offers abandons it,
+// whoever claims it first.
+type pendingLaunch struct {
+	claimed int32
+	offers  []string
+	tasks   []*mesos.TaskInfo
+}
+
+func (l *pendingLaunch) claim() bool {
+	return atomic.CompareAndSwapInt32(&l.claimed, 0, 1)
+}
+
+// rescind abandons the pending launch on the rescinded offer id, queues
+// its tasks again and declines the other offers of the launch.
+func (s *scheduler) rescind(id string) {
+	l, ok := s.launches[id]
+	if !ok {
+		return
+	}
+	for _, offer := range l.offers {
+		delete(s.launches, offer)
+	}
+	if !l.claim() {
+		// already sent, the master answers with invalid offer updates
+		return
+	}
+	log.Println("Offer ", id, " rescinded, abandoning launch of ", len(l.tasks), " tasks")
+	for _, task := range l.tasks {
+		taskID := task.GetTaskId().GetValue()
+		attempt := 1
+		if rec, ok := s.tasks.get(taskID); ok {
+			attempt = rec.attempt()
+		}
+		s.forgetTask(taskID)
+		s.retries = append(s.retries, attempt)
+	}
+
+	var unused []*mesos.OfferID
+	for _, offer := range l.offers {
+		if offer != id {
+			unused = append(unused, &mesos.OfferID{Value: proto.String(offer)})
+		}
+	}
+	if len(unused) > 0 {
+		s.pool.submit(func() {
+			if err := s.callClient.Decline(unused, nil); err != nil {
+				log.Println("Unable to send Decline Call: ", err)
+			}
+		})
+	}
+}
+
+// invalidOffer reports whether status tells a task was not launched
+// because its offer was no longer valid, e.g. rescinded.
+func invalidOffer(status *mesos.TaskStatus) bool {
+	switch status.GetState() {
+	case mesos.TaskState_TASK_LOST, mesos.TaskState_TASK_ERROR:
+		return status.GetReason() == mesos.TaskStatus_REASON_INVALID_OFFERS
+	}
+	return false
+}
+
+// relaunch queues the attempt of rec again which was never launched
+// because its offer was invalid. It does not count as failed attempt.
+func (s *scheduler) relaunch(rec taskRecord) (taskRecord, func()) {
+	log.Println("Task ", rec.ID, " not launched because of an invalid offer, relaunching")
+	rec = s.tasks.setOutcome(rec.ID, "invalid offer, relaunching")
+	attempt := rec.attempt()
+	return rec, func() {
+		s.retries = append(s.retries, attempt)
+	}
+}
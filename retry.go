@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+)
+
+// exhaustAction is what happens when a task of the job failed for good
+type exhaustAction int
+
+const (
+	// giveUp drops the task, the next run starts fresh tasks
+	giveUp exhaustAction = iota
+	// pause stops launching tasks until the job is resumed
+	pause
+	// exit terminates the scheduler
+	exit
+)
+
+// parseExhaustAction parses giveup, pause or exit
+func parseExhaustAction(s string) (exhaustAction, error) {
+	switch s {
+	case "giveup":
+		return giveUp, nil
+	case "pause":
+		return pause, nil
+	case "exit":
+		return exit, nil
+	}
+	return giveUp, fmt.Errorf("unknown action %q, expecting giveup, pause or exit", s)
+}
+
+// retryPolicy decides whether failed tasks of the job are launched again
+type retryPolicy struct {
+	// maxAttempts is the number of launches of a task including the first
+	maxAttempts int
+	// minBackoff is the wait before the second attempt, it doubles with
+	// every further attempt up to maxBackoff
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	// states are the retryable terminal states
+	states map[mesos.TaskState]bool
+	// reasons limit retries to these reasons if not empty
+	reasons map[mesos.TaskStatus_Reason]bool
+	// exhausted is applied to tasks that failed for good
+	exhausted exhaustAction
+}
+
+// defaultRetryPolicy retries failed and lost tasks up to three times
+func defaultRetryPolicy() retryPolicy {
+	return retryPolicy{
+		maxAttempts: 3,
+		minBackoff:  10 * time.Second,
+		maxBackoff:  5 * time.Minute,
+		states: map[mesos.TaskState]bool{
+			mesos.TaskState_TASK_FAILED: true,
+			mesos.TaskState_TASK_LOST:   true,
+		},
+		exhausted: giveUp,
+	}
+}
+
+// parseTaskStates parses a comma separated list like TASK_FAILED,TASK_LOST
+func parseTaskStates(list string) (map[mesos.TaskState]bool, error) {
+	states := make(map[mesos.TaskState]bool)
+	for _, name := range splitList(list) {
+		v, ok := mesos.TaskState_value[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown task state %s", name)
+		}
+		states[mesos.TaskState(v)] = true
+	}
+	return states, nil
+}
+
+// parseReasons parses a comma separated list like REASON_AGENT_REMOVED
+func parseReasons(list string) (map[mesos.TaskStatus_Reason]bool, error) {
+	reasons := make(map[mesos.TaskStatus_Reason]bool)
+	for _, name := range splitList(list) {
+		v, ok := mesos.TaskStatus_Reason_value[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown reason %s", name)
+		}
+		reasons[mesos.TaskStatus_Reason(v)] = true
+	}
+	return reasons, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// retryable reports whether a task ending with status may be retried
+func (p retryPolicy) retryable(status *mesos.TaskStatus) bool {
+	if !p.states[status.GetState()] {
+		return false
+	}
+	return len(p.reasons) == 0 || p.reasons[status.GetReason()]
+}
+
+// backoff returns the wait before launching attempt+1
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.minBackoff
+	for i := 1; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return d
+}
+
+// failed reports whether state means the task did not finish its work
+func failed(state mesos.TaskState) bool {
+	return terminal(state) && state != mesos.TaskState_TASK_FINISHED
+}
+
+// taskFailed applies the retry policy to the failed task rec. It
+// records the decision in the task and returns an action to run once
+// the update is persisted and its acknowledgement is queued.
+func (s *scheduler) taskFailed(rec taskRecord, status *mesos.TaskStatus) (taskRecord, func()) {
+	details := fmt.Sprint(
+		"task ", rec.ID,
+		" in state ", status.GetState().String(),
+		" with reason ", status.GetReason().String(),
+		" from source ", status.GetSource().String(),
+		" with message ", status.GetMessage(),
+	)
+	attempt := rec.attempt()
+	// timed out tasks are killed by us and always retryable
+	retryable := rec.TimedOut != "" || s.retry.retryable(status)
+	if rec.TimedOut != "" {
+		details += ", " + timeoutOutcome(rec)
+	}
+
+	if !retryable || attempt >= s.retry.maxAttempts {
+		why := "not retryable"
+		if retryable {
+			why = fmt.Sprintf("%d attempts failed", attempt)
+		}
+		return s.exhausted(rec, details, why)
+	}
+
+	backoff := s.retry.backoff(attempt)
+	outcome := fmt.Sprintf("retrying as attempt %d in %s", attempt+1, backoff)
+	log.Println("Failed ", details, ", ", outcome)
+	rec = s.tasks.setOutcome(rec.ID, outcome)
+
+	return rec, func() {
+		s.retryWaiting++
+		time.AfterFunc(backoff, func() {
+			s.do(func() {
+				s.retryWaiting--
+				s.retries = append(s.retries, attempt+1)
+			})
+		})
+	}
+}
+
+// exhausted applies the exhaust action of the retry policy to rec
+func (s *scheduler) exhausted(rec taskRecord, details, why string) (taskRecord, func()) {
+	switch s.retry.exhausted {
+	case pause:
+		rec = s.tasks.setOutcome(rec.ID, why+", pausing job")
+		log.Println("Failed ", details, ", ", why, ", pausing job")
+		s.paused = true
+		return rec, nil
+	case exit:
+		rec = s.tasks.setOutcome(rec.ID, why+", exiting")
+		return rec, func() {
+			// shut down so queued calls like the acknowledgement are
+			// sent before main exits with exitErr
+			s.exitErr = fmt.Errorf("Exiting because of failed %s, %s", details, why)
+			log.Println(s.exitErr)
+			s.drain()
+		}
+	}
+	rec = s.tasks.setOutcome(rec.ID, why+", giving up")
+	log.Println("Failed ", details, ", ", why, ", giving up")
+	return rec, nil
+}
+
+// resume continues launching tasks of a paused job
+func (s *scheduler) resume() {
+	if s.paused {
+		log.Println("Resuming job")
+		s.paused = false
+	}
+}
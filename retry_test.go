@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/fakemaster"
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+)
+
+func TestRetryable(t *testing.T) {
+	p := defaultRetryPolicy()
+	status := func(state mesos.TaskState, reason mesos.TaskStatus_Reason) *mesos.TaskStatus {
+		return &mesos.TaskStatus{State: state.Enum(), Reason: reason.Enum()}
+	}
+	if !p.retryable(status(mesos.TaskState_TASK_FAILED, mesos.TaskStatus_REASON_COMMAND_EXECUTOR_FAILED)) {
+		t.Error("failed task not retryable")
+	}
+	if p.retryable(status(mesos.TaskState_TASK_KILLED, mesos.TaskStatus_REASON_COMMAND_EXECUTOR_FAILED)) {
+		t.Error("killed task retryable")
+	}
+
+	p.reasons = map[mesos.TaskStatus_Reason]bool{mesos.TaskStatus_REASON_AGENT_REMOVED: true}
+	if !p.retryable(status(mesos.TaskState_TASK_LOST, mesos.TaskStatus_REASON_AGENT_REMOVED)) {
+		t.Error("lost task with listed reason not retryable")
+	}
+	if p.retryable(status(mesos.TaskState_TASK_LOST, mesos.TaskStatus_REASON_COMMAND_EXECUTOR_FAILED)) {
+		t.Error("lost task with other reason retryable")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{minBackoff: 10 * time.Second, maxBackoff: time.Minute}
+	for attempt, want := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		4:  time.Minute,
+		10: time.Minute,
+	} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %s, expected %s", attempt, got, want)
+		}
+	}
+}
+
+// startRetrying starts a scheduler retrying failed tasks once and
+// launches two tasks on one offer
+func startRetrying(t *testing.T, m *fakemaster.Master, action exhaustAction) (*scheduler, []string) {
+	s := startScheduler(t, m, func(s *scheduler) {
+		s.retry.maxAttempts = 2
+		s.retry.minBackoff = 20 * time.Millisecond
+		s.retry.maxBackoff = 20 * time.Millisecond
+		s.retry.exhausted = action
+	})
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-1", "agent-1", 1)))
+	waitCall(t, m, sched.Call_ACCEPT, 1)
+	var ids []string
+	for _, task := range fakemaster.LaunchedTasks(m.Calls()) {
+		ids = append(ids, task.GetTaskId().GetValue())
+	}
+	return s, ids
+}
+
+func TestRetryGiveUp(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s, tasks := startRetrying(t, m, giveUp)
+
+	send(t, m, fakemaster.UpdateEvent(tasks[0], "agent-1", mesos.TaskState_TASK_FAILED))
+	eventually(t, s, "the retry is queued", func() bool { return len(s.retries) == 1 })
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-2", "agent-1", 1)))
+	waitCall(t, m, sched.Call_ACCEPT, 2)
+	launched := fakemaster.LaunchedTasks(m.Calls())
+	retried := launched[len(launched)-1].GetTaskId().GetValue()
+	inLoop(s, func() {
+		if rec, _ := s.tasks.get(retried); rec.attempt() != 2 {
+			t.Errorf("relaunched as attempt %d", rec.attempt())
+		}
+	})
+
+	// the second attempt failing exhausts the retries
+	send(t, m, fakemaster.UpdateEvent(retried, "agent-1", mesos.TaskState_TASK_FAILED))
+	waitCall(t, m, sched.Call_ACKNOWLEDGE, 2)
+	inLoop(s, func() {
+		rec, _ := s.tasks.get(retried)
+		if !strings.Contains(rec.Outcome, "giving up") {
+			t.Errorf("outcome %q", rec.Outcome)
+		}
+		if len(s.retries) != 0 || s.retryWaiting != 0 || s.paused {
+			t.Errorf("retries %v, waiting %d, paused %v", s.retries, s.retryWaiting, s.paused)
+		}
+	})
+}
+
+func TestRetryPause(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s, tasks := startRetrying(t, m, pause)
+
+	// one task waits for its retry while the other one pauses the job
+	send(t, m, fakemaster.UpdateEvent(tasks[0], "agent-1", mesos.TaskState_TASK_FAILED))
+	send(t, m, fakemaster.UpdateEvent(tasks[1], "agent-1", mesos.TaskState_TASK_ERROR))
+	eventually(t, s, "the job is paused with a pending retry", func() bool {
+		return s.paused && len(s.retries) == 1
+	})
+
+	// a paused job launches no retries either
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-2", "agent-1", 1)))
+	waitCall(t, m, sched.Call_DECLINE, 1)
+	if tasks := fakemaster.LaunchedTasks(m.Calls()); len(tasks) != 2 {
+		t.Fatalf("launched %d tasks while paused", len(tasks)-2)
+	}
+
+	inLoop(s, s.resume)
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-3", "agent-1", 1)))
+	waitCall(t, m, sched.Call_ACCEPT, 2)
+}
+
+func TestRetryExit(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s, tasks := startRetrying(t, m, exit)
+
+	send(t, m, fakemaster.UpdateEvent(tasks[0], "agent-1", mesos.TaskState_TASK_ERROR))
+	select {
+	case <-s.doneChan:
+	case <-time.After(callTimeout):
+		t.Fatal("scheduler did not exit")
+	}
+	if s.exitErr == nil {
+		t.Error("no error to exit with")
+	}
+	// the update was acknowledged before exiting
+	if calls, _ := m.WaitCall(sched.Call_ACKNOWLEDGE, 1, 0); len(calls) != 1 {
+		t.Errorf("sent %d acknowledgements", len(calls))
+	}
+}
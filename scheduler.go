@@ -1,175 +1,459 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/bogue1979/mesos-http-scheduler/client"
+	"github.com/bogue1979/mesos-http-scheduler/discovery"
+	mesosjson "github.com/bogue1979/mesos-http-scheduler/mesos/json"
 	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
 	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
 	"github.com/gogo/protobuf/proto"
 )
 
-// Scheduler represents a Mesos scheduler
+// Scheduler represents a Mesos scheduler.
+//
+// The scheduler state is owned by the event loop in handleEvents which
+// handles events, timer ticks and commands one at a time. Calls to the
+// master are run by a worker pool and report back via do.
 type scheduler struct {
-	framework    *mesos.FrameworkInfo
-	executor     *mesos.ExecutorInfo
-	command      *mesos.CommandInfo
-	taskLaunched int
-	maxTasks     int
+	framework *mesos.FrameworkInfo
+	executor  *mesos.ExecutorInfo
+	command   *mesos.CommandInfo
+	tasks     *taskRegistry
+	maxTasks  int
 
 	client     *client.Client
-	callClient *client.Client
+	callClient *client.Scheduler
 	cpuPerTask float64
 	memPerTask float64
+	placement  placement
 	waitTime   int64
 	events     chan *sched.Event
+	commands   chan func()
 	doneChan   chan struct{}
 	acceptNew  bool
+
+	// loopDone is closed when the event loop stops, doneChan once the
+	// queued calls are sent as well. exitErr is the reason to exit with
+	// an error then.
+	loopDone chan struct{}
+	exitErr  error
+
+	// retry is the policy for failed tasks, retries holds the attempts
+	// ready to launch and retryWaiting counts retries in backoff
+	retry        retryPolicy
+	retries      []int
+	retryWaiting int
+	// paused stops launching new tasks after a task failed for good
+	paused bool
+
+	// constraints limit the agents tasks are placed on
+	constraints []constraint
+
+	// maxRuntime and stagingTimeout limit the time tasks run or stage
+	// before they are killed with killGrace as grace period
+	maxRuntime     time.Duration
+	stagingTimeout time.Duration
+	killGrace      time.Duration
+
+	// shutdownWait bounds the wait for active tasks on shutdown, tasks
+	// still active are killed with shutdownKill before shutdownAction
+	shutdownWait   time.Duration
+	shutdownKill   bool
+	shutdownAction shutdownAction
+	draining       bool
+	shutdownDone   bool
+
+	// reconcileInterval is the time between periodic reconciliations,
+	// tasks in reconcilePending did not get an update yet
+	reconcileInterval  time.Duration
+	reconcilePending   map[string]string
+	reconcileBackoff   time.Duration
+	reconcileScheduled bool
+
+	// suppressed is set while offers are suppressed, nextWait is the
+	// time acceptNew is set again
+	suppressed        bool
+	offersCallPending bool
+	nextWait          time.Time
+	// launches are the ACCEPT calls in flight by offer ID
+	launches map[string]*pendingLaunch
+
+	// currentRun is the current job run, nil once maxTasks was reached
+	currentRun *jobRun
+
+	// pool runs calls to the master
+	pool        *workerPool
+	callWorkers int
+
+	// detector finds the current leading master on reconnect
+	detector discovery.Detector
+	// minBackoff and maxBackoff bound the wait between reconnect attempts
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// store keeps the framework ID, tasks and runs across restarts
+	store stateStore
+
+	// conn tracks heartbeats of the current subscription
+	conn                connState
+	maxMissedHeartbeats int
+
+	streamMu sync.Mutex
+	master   string
+	stream   io.Closer
+	stopOnce sync.Once
+	stopChan chan struct{}
 }
 
 // New returns a pointer to new Scheduler
 func newSched(master string, fw *mesos.FrameworkInfo, cmd *mesos.CommandInfo, mem, cpu float64, wait int64) *scheduler {
+	c := client.New(master, "/api/v1/scheduler")
+	callClient := client.NewScheduler(c)
+	callClient.SetFrameworkID(fw.GetId())
 	return &scheduler{
-		client:     client.New(master, "/api/v1/scheduler"),
+		client:     c,
+		callClient: callClient,
 		framework:  fw,
+		tasks:      newTaskRegistry(),
+		store:      newMemStore(),
+		retry:      defaultRetryPolicy(),
 		command:    cmd,
 		cpuPerTask: cpu,
 		memPerTask: mem,
 		maxTasks:   5,
 		waitTime:   wait,
 		events:     make(chan *sched.Event),
+		commands:   make(chan func()),
 		doneChan:   make(chan struct{}),
+		loopDone:   make(chan struct{}),
 		acceptNew:  true,
+		launches:   make(map[string]*pendingLaunch),
+		detector:   discovery.NewStatic([]string{master}, 10*time.Second),
+		master:     master,
+		minBackoff: time.Second,
+		maxBackoff: 30 * time.Second,
+		stopChan:   make(chan struct{}),
+
+		maxMissedHeartbeats: 5,
+		callWorkers:         4,
 	}
 }
 
 // start starts the scheduler and subscribes to event stream
 // returns a channel to wait for completion.
 func (s *scheduler) start() <-chan struct{} {
-	if err := s.subscribe(); err != nil {
+	resp, err := s.subscribe()
+	if err != nil {
 		log.Fatal(err)
 	}
+	go s.run(resp)
+	if n, ok := s.detector.(discovery.Notifier); ok {
+		go s.followLeader(n.Changes())
+	}
+	s.pool = newWorkerPool(s.callWorkers)
 	go s.handleEvents()
-	go s.acceptOffers()
 	return s.doneChan
 }
 
 func (s *scheduler) stop() {
-	close(s.events)
+	s.stopOnce.Do(func() { close(s.stopChan) })
+	s.streamMu.Lock()
+	if s.stream != nil {
+		s.stream.Close()
+	}
+	s.streamMu.Unlock()
 }
 
-func (s *scheduler) send(call *sched.Call) (*http.Response, error) {
-	payload, err := proto.Marshal(call)
+// run reads the event stream of resp and resubscribes to the current
+// leading master whenever the stream ends until the scheduler is stopped
+// or the master sent an error. The backoff starts over once subscribed.
+func (s *scheduler) run(resp *http.Response) {
+	defer close(s.events)
+	backoff := s.minBackoff
+	for {
+		if resp != nil {
+			subscribed, failed := s.qEvents(resp)
+			log.Println("Event stream from master closed")
+			s.conn.setDisconnected("event stream closed")
+			if failed {
+				// the master ended the subscription on purpose
+				return
+			}
+			if subscribed {
+				backoff = s.minBackoff
+			}
+		}
+
+		select {
+		case <-s.stopChan:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+
+		var err error
+		if resp, err = s.resubscribe(); err != nil {
+			log.Println("Unable to resubscribe: ", err)
+		}
+	}
+}
+
+// resubscribe discovers the leading master, points the client to it
+// and subscribes again with the known framework ID.
+func (s *scheduler) resubscribe() (*http.Response, error) {
+	master, err := s.detector.Leader()
 	if err != nil {
 		return nil, err
 	}
-	return s.client.Send(payload)
+	log.Println("Resubscribing to master ", master)
+	s.streamMu.Lock()
+	s.master = master
+	s.streamMu.Unlock()
+	s.client.Reset(master)
+	return s.subscribe()
+}
+
+// followLeader closes the event stream whenever the detector reports
+// a leader other than the master we are subscribed to, so run
+// resubscribes to the new leader.
+func (s *scheduler) followLeader(changes <-chan string) {
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case leader := <-changes:
+			s.streamMu.Lock()
+			if leader != s.master && s.stream != nil {
+				log.Println("Leading master changed to ", leader)
+				s.stream.Close()
+			}
+			s.streamMu.Unlock()
+		}
+	}
+}
+
+func (s *scheduler) send(call *sched.Call) (*http.Response, error) {
+	return s.client.SendCall(call)
 }
 
 // Subscribe subscribes the scheduler to the Mesos cluster.
 // It keeps the http connection opens with the Master to stream
 // subsequent events.
-func (s *scheduler) subscribe() error {
+func (s *scheduler) subscribe() (*http.Response, error) {
+	fw := *s.framework
+	fw.Id = s.callClient.FrameworkID()
 	call := &sched.Call{
-		Type: sched.Call_SUBSCRIBE.Enum(),
+		FrameworkId: fw.Id,
+		Type:        sched.Call_SUBSCRIBE.Enum(),
 		Subscribe: &sched.Call_Subscribe{
-			FrameworkInfo: s.framework,
+			FrameworkInfo: &fw,
 		},
 	}
 
 	resp, err := s.send(call)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Subscribe with unexpected response status: %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, fmt.Errorf("Subscribe with unexpected response status: %d", resp.StatusCode)
 	}
-	debugLog(fmt.Sprintln("Mesos-Stream-Id:", s.client.StreamID))
-
-	go s.qEvents(resp)
+	debugLog(fmt.Sprintln("Mesos-Stream-Id:", s.client.StreamID()))
 
-	return nil
+	return resp, nil
 }
 
-func (s *scheduler) qEvents(resp *http.Response) {
+// qEvents queues the events of the subscription response until
+// the stream ends. It reports whether SUBSCRIBED or ERROR was received.
+func (s *scheduler) qEvents(resp *http.Response) (subscribed, failed bool) {
+	s.streamMu.Lock()
+	s.stream = resp.Body
+	select {
+	case <-s.stopChan:
+		// stopped before the stream was registered
+		resp.Body.Close()
+	default:
+	}
+	s.streamMu.Unlock()
 	defer func() {
+		s.streamMu.Lock()
+		s.stream = nil
+		s.streamMu.Unlock()
 		resp.Body.Close()
-		close(s.events)
 	}()
-	dec := json.NewDecoder(resp.Body)
+
+	done := make(chan struct{})
+	defer close(done)
+	s.conn.seen()
+	go s.watchHeartbeats(resp.Body, done)
+
+	rd := client.NewRecordIOReader(resp.Body)
 	for {
-		event := new(sched.Event)
-		if err := dec.Decode(event); err != nil {
-			if err == io.EOF {
-				return
+		record, err := rd.ReadRecord()
+		if err != nil {
+			if err != io.EOF {
+				log.Println("Unable to read event stream: ", err)
 			}
+			return
+		}
+		s.conn.seen()
+		event, err := s.decodeEvent(record)
+		if err != nil {
+			log.Println("Unable to decode event: ", err)
 			continue
 		}
 		s.events <- event
+		switch event.GetType() {
+		case sched.Event_SUBSCRIBED:
+			subscribed = true
+		case sched.Event_ERROR:
+			return subscribed, true
+		}
 	}
 }
 
-func (s *scheduler) acceptOffers() {
-	c := time.Tick(time.Duration(s.waitTime) * time.Second)
-	for now := range c {
-		if s.acceptNew != true {
-			debugLog(fmt.Sprintf("%s scheduler accept new work", now))
-			s.acceptNew = true
-		}
+// decodeEvent decodes a single event frame in the format requested
+// from the master.
+func (s *scheduler) decodeEvent(record []byte) (*sched.Event, error) {
+	event := new(sched.Event)
+	if s.client.Accept == client.MediaProtobuf {
+		return event, proto.Unmarshal(record, event)
+	}
+	return event, mesosjson.Unmarshal(record, event)
+}
+
+// acceptWork lets the scheduler launch new tasks again once the
+// wait time passed.
+func (s *scheduler) acceptWork(now time.Time) {
+	if s.acceptNew != true {
+		debugLog(fmt.Sprintf("%s scheduler accept new work", now))
+		s.acceptNew = true
 	}
 }
 
+// do runs cmd in the event loop. It is used by calls running in the
+// worker pool to update the scheduler state.
+func (s *scheduler) do(cmd func()) {
+	select {
+	case s.commands <- cmd:
+	case <-s.loopDone:
+	}
+}
+
+// handleEvents is the event loop of the scheduler
 func (s *scheduler) handleEvents() {
+	// queued calls are sent before the scheduler is done
 	defer close(s.doneChan)
-	for ev := range s.events {
-		switch ev.GetType() {
-
-		case sched.Event_SUBSCRIBED:
-			sub := ev.GetSubscribed()
-			s.framework.Id = sub.FrameworkId
-			log.Println("Subscribed: FrameworkID: ", sub.FrameworkId.GetValue())
-
-		case sched.Event_OFFERS:
-			offers := ev.GetOffers().GetOffers()
-			debugLog(fmt.Sprintln("Received ", len(offers), " offers "))
-			go s.offers(offers)
-
-		case sched.Event_RESCIND:
-			debugLog(fmt.Sprintln("Received rescind offers"))
-
-		case sched.Event_UPDATE:
-			status := ev.GetUpdate().GetStatus()
-			go s.status(status)
-
-		case sched.Event_MESSAGE:
-			log.Println("Received message event")
-
-		case sched.Event_FAILURE:
-			log.Println("Received failure event")
-			fail := ev.GetFailure()
-			if fail.ExecutorId != nil {
-				log.Println(
-					"Executor ", fail.ExecutorId.GetValue(), " terminated ",
-					" with status ", fail.GetStatus(),
-					" on agent ", fail.GetAgentId().GetValue(),
-				)
-			} else {
-				if fail.GetAgentId() != nil {
-					log.Println("Agent ", fail.GetAgentId().GetValue(), " failed ")
-				}
+	defer s.pool.close()
+	defer func() {
+		if err := s.store.Close(); err != nil {
+			log.Println("Unable to save state: ", err)
+		}
+	}()
+	defer close(s.loopDone)
+	waitInterval := time.Duration(s.waitTime) * time.Second
+	var wait <-chan time.Time
+	if waitInterval > 0 {
+		t := time.NewTicker(waitInterval)
+		defer t.Stop()
+		wait = t.C
+		s.nextWait = time.Now().Add(waitInterval)
+	}
+	var reconcile <-chan time.Time
+	if s.reconcileInterval > 0 {
+		t := time.NewTicker(s.reconcileInterval)
+		defer t.Stop()
+		reconcile = t.C
+	}
+	deadlines := time.NewTicker(time.Second)
+	defer deadlines.Stop()
+	for {
+		select {
+		case ev, ok := <-s.events:
+			if !ok {
+				return
 			}
+			s.handleEvent(ev)
+		case now := <-wait:
+			s.nextWait = now.Add(waitInterval)
+			s.acceptWork(now)
+		case <-reconcile:
+			s.reconcile()
+		case now := <-deadlines.C:
+			s.checkDeadlines(now)
+		case cmd := <-s.commands:
+			cmd()
+		}
+		s.updateOffers()
+	}
+}
 
-		case sched.Event_ERROR:
-			err := ev.GetError().GetMessage()
-			log.Println(err)
+func (s *scheduler) handleEvent(ev *sched.Event) {
+	switch ev.GetType() {
+
+	case sched.Event_SUBSCRIBED:
+		sub := ev.GetSubscribed()
+		s.callClient.SetFrameworkID(sub.FrameworkId)
+		s.saveFrameworkID()
+		s.conn.setSubscribed(time.Duration(sub.GetHeartbeatIntervalSeconds() * float64(time.Second)))
+		// a new subscription receives offers until suppressed again
+		s.suppressed = false
+		log.Println("Subscribed: FrameworkID: ", sub.FrameworkId.GetValue())
+		// learn what happened to our tasks while we were away
+		s.reconcile()
+
+	case sched.Event_OFFERS:
+		offers := ev.GetOffers().GetOffers()
+		debugLog(fmt.Sprintln("Received ", len(offers), " offers "))
+		s.offers(offers)
+
+	case sched.Event_RESCIND:
+		id := ev.GetRescind().GetOfferId().GetValue()
+		debugLog(fmt.Sprintln("Received rescind offer ", id))
+		s.rescind(id)
+
+	case sched.Event_UPDATE:
+		status := ev.GetUpdate().GetStatus()
+		s.status(status)
 
-		case sched.Event_HEARTBEAT:
-			debugLog(fmt.Sprintln("HEARTBEAT"))
+	case sched.Event_MESSAGE:
+		log.Println("Received message event")
+
+	case sched.Event_FAILURE:
+		log.Println("Received failure event")
+		fail := ev.GetFailure()
+		if fail.ExecutorId != nil {
+			log.Println(
+				"Executor ", fail.ExecutorId.GetValue(), " terminated ",
+				" with status ", fail.GetStatus(),
+				" on agent ", fail.GetAgentId().GetValue(),
+			)
+		} else {
+			if fail.GetAgentId() != nil {
+				log.Println("Agent ", fail.GetAgentId().GetValue(), " failed ")
+			}
 		}
+
+	case sched.Event_ERROR:
+		// the master closes the subscription, e.g. because the framework
+		// was removed or failed over to another scheduler
+		s.exitErr = fmt.Errorf("Master sent error: %s", ev.GetError().GetMessage())
+		log.Println(s.exitErr)
+		if err := s.store.SaveFrameworkID(""); err != nil {
+			log.Println("Unable to clear framework ID: ", err)
+		}
+		s.stop()
+
+	case sched.Event_HEARTBEAT:
+		debugLog(fmt.Sprintln("HEARTBEAT"))
 	}
 }
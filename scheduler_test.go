@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/client"
+	"github.com/bogue1979/mesos-http-scheduler/fakemaster"
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+	"github.com/gogo/protobuf/proto"
+)
+
+const callTimeout = 5 * time.Second
+
+// startScheduler subscribes a scheduler running up to two tasks to m
+// and stops it at the end of the test
+func startScheduler(t *testing.T, m *fakemaster.Master, setup func(*scheduler)) *scheduler {
+	fw := &mesos.FrameworkInfo{
+		User: proto.String("test"),
+		Name: proto.String("test"),
+	}
+	cmd := &mesos.CommandInfo{Shell: proto.Bool(true), Value: proto.String("true")}
+	s := newSched(m.Addr(), fw, cmd, 64, 0.1, 60)
+	s.maxTasks = 2
+	s.minBackoff = 10 * time.Millisecond
+	s.maxBackoff = 50 * time.Millisecond
+	s.reconcileInterval = 0
+	if setup != nil {
+		setup(s)
+	}
+	done := s.start()
+	t.Cleanup(func() {
+		s.stop()
+		<-done
+	})
+	return s
+}
+
+// inLoop runs f in the event loop of s and waits for it
+func inLoop(s *scheduler, f func()) {
+	ran := make(chan struct{})
+	s.do(func() {
+		f()
+		close(ran)
+	})
+	<-ran
+}
+
+// eventually polls cond in the event loop of s until it holds
+func eventually(t *testing.T, s *scheduler, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(callTimeout)
+	for {
+		var ok bool
+		inLoop(s, func() { ok = cond() })
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting until ", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitCall(t *testing.T, m *fakemaster.Master, typ sched.Call_Type, n int) []*sched.Call {
+	t.Helper()
+	calls, err := m.WaitCall(typ, n, callTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return calls
+}
+
+func send(t *testing.T, m *fakemaster.Master, ev *sched.Event) {
+	t.Helper()
+	if err := m.Send(ev); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// portsOffer returns an offer with a range of ports besides cpus and mem
+func portsOffer(id, agent string, cpus float64) *mesos.Offer {
+	offer := fakemaster.Offer(id, agent, agent+".example.com", cpus, 1024)
+	offer.Resources = append(offer.Resources, &mesos.Resource{
+		Name: proto.String("ports"),
+		Type: mesos.Value_RANGES.Enum(),
+		Ranges: &mesos.Value_Ranges{Range: []*mesos.Value_Range{
+			{Begin: proto.Uint64(31000), End: proto.Uint64(32000)},
+		}},
+	})
+	return offer
+}
+
+func TestSubscribe(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s := startScheduler(t, m, func(s *scheduler) {
+		s.framework.FailoverTimeout = proto.Float64(60)
+	})
+
+	sub := waitCall(t, m, sched.Call_SUBSCRIBE, 1)[0]
+	if name := sub.GetSubscribe().GetFrameworkInfo().GetName(); name != "test" {
+		t.Errorf("subscribed framework %q", name)
+	}
+	// tasks are reconciled right after SUBSCRIBED
+	rec := waitCall(t, m, sched.Call_RECONCILE, 1)[0]
+	id := rec.GetFrameworkId().GetValue()
+	if id == "" {
+		t.Fatal("RECONCILE without framework ID")
+	}
+	inLoop(s, func() {
+		if got := s.callClient.FrameworkID().GetValue(); got != id {
+			t.Errorf("framework ID %q, master assigned %q", got, id)
+		}
+	})
+	st, _ := s.store.Load()
+	if st.FrameworkID != id {
+		t.Errorf("stored framework ID %q, expected %q", st.FrameworkID, id)
+	}
+}
+
+func TestSubscribePrincipal(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	startScheduler(t, m, func(s *scheduler) {
+		s.framework.Principal = proto.String("principal")
+		s.client.SetCredentials("principal", "secret")
+	})
+
+	sub := waitCall(t, m, sched.Call_SUBSCRIBE, 1)[0]
+	if p := sub.GetSubscribe().GetFrameworkInfo().GetPrincipal(); p != "principal" {
+		t.Errorf("subscribed with principal %q", p)
+	}
+}
+
+func TestFrameworkIDNotKeptWithoutFailover(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s := startScheduler(t, m, nil)
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+
+	st, _ := s.store.Load()
+	if st.FrameworkID != "" {
+		t.Errorf("stored framework ID %q without failover timeout", st.FrameworkID)
+	}
+}
+
+func TestMasterError(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s := startScheduler(t, m, func(s *scheduler) {
+		s.framework.FailoverTimeout = proto.Float64(60)
+	})
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+
+	send(t, m, fakemaster.ErrorEvent("Framework has been removed"))
+	m.Disconnect()
+	select {
+	case <-s.doneChan:
+	case <-time.After(callTimeout):
+		t.Fatal("scheduler did not stop after an error")
+	}
+	if s.exitErr == nil {
+		t.Error("no error to exit with")
+	}
+	// the subscription ended on purpose, it is not renewed
+	time.Sleep(100 * time.Millisecond)
+	if calls, _ := m.WaitCall(sched.Call_SUBSCRIBE, 2, 0); len(calls) != 1 {
+		t.Errorf("subscribed %d times", len(calls))
+	}
+	st, _ := s.store.Load()
+	if st.FrameworkID != "" {
+		t.Errorf("framework ID %q kept after error", st.FrameworkID)
+	}
+}
+
+func TestBackoffWithoutSubscribed(t *testing.T) {
+	// a master ending every stream before SUBSCRIBED
+	var subscribes int32
+	var addr string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/master/redirect" {
+			w.Header().Set("Location", "//"+addr)
+			w.WriteHeader(http.StatusTemporaryRedirect)
+			return
+		}
+		atomic.AddInt32(&subscribes, 1)
+		w.Header().Set("Mesos-Stream-Id", "stream")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	addr = strings.TrimPrefix(srv.URL, "http://")
+
+	fw := &mesos.FrameworkInfo{User: proto.String("test"), Name: proto.String("test")}
+	s := newSched(addr, fw, &mesos.CommandInfo{}, 64, 0.1, 60)
+	s.minBackoff = 10 * time.Millisecond
+	s.maxBackoff = 80 * time.Millisecond
+	done := s.start()
+	time.Sleep(400 * time.Millisecond)
+	s.stop()
+	<-done
+
+	// 10, 20, 40 and 80ms apart, not every 10ms
+	if n := atomic.LoadInt32(&subscribes); n > 10 {
+		t.Errorf("subscribed %d times, backoff does not grow", n)
+	}
+}
+
+func TestOffersAccept(t *testing.T) {
+	formats := []struct {
+		name        string
+		accept      string
+		contentType string
+	}{
+		{"json", client.MediaJSON, client.MediaJSON},
+		{"protobuf", client.MediaProtobuf, client.MediaProtobuf},
+	}
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			m := fakemaster.New()
+			defer m.Close()
+			startScheduler(t, m, func(s *scheduler) {
+				s.client.Accept = format.accept
+				s.client.ContentType = format.contentType
+			})
+			waitCall(t, m, sched.Call_RECONCILE, 1)
+
+			send(t, m, fakemaster.OffersEvent(portsOffer("offer-1", "agent-1", 1)))
+			accept := waitCall(t, m, sched.Call_ACCEPT, 1)[0]
+			if ids := accept.GetAccept().GetOfferIds(); len(ids) != 1 || ids[0].GetValue() != "offer-1" {
+				t.Errorf("accepted offers %v", ids)
+			}
+			tasks := fakemaster.LaunchedTasks(m.Calls())
+			if len(tasks) != 2 {
+				t.Fatalf("launched %d tasks, expected 2", len(tasks))
+			}
+			for _, task := range tasks {
+				if task.GetAgentId().GetValue() != "agent-1" {
+					t.Errorf("task launched on %s", task.GetAgentId().GetValue())
+				}
+			}
+
+			// maxTasks are running, further offers are declined
+			send(t, m, fakemaster.OffersEvent(portsOffer("offer-2", "agent-2", 1)))
+			decline := waitCall(t, m, sched.Call_DECLINE, 1)[0]
+			if ids := decline.GetDecline().GetOfferIds(); len(ids) != 1 || ids[0].GetValue() != "offer-2" {
+				t.Errorf("declined offers %v", ids)
+			}
+		})
+	}
+}
+
+func TestRunPerJobRun(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s := startScheduler(t, m, nil)
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+
+	// the offers fit one task each, both tasks belong to the same run
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-1", "agent-1", 0.1)))
+	waitCall(t, m, sched.Call_ACCEPT, 1)
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-2", "agent-2", 0.1)))
+	waitCall(t, m, sched.Call_ACCEPT, 2)
+
+	var runs []jobRun
+	inLoop(s, func() {
+		st, _ := s.store.Load()
+		runs = st.Runs
+	})
+	if len(runs) != 1 || len(runs[0].Tasks) != 2 {
+		t.Errorf("recorded runs %+v, expected one run of two tasks", runs)
+	}
+}
+
+func TestUpdateAcknowledged(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s := startScheduler(t, m, nil)
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-1", "agent-1", 1)))
+	waitCall(t, m, sched.Call_ACCEPT, 1)
+	task := fakemaster.LaunchedTasks(m.Calls())[0].GetTaskId().GetValue()
+
+	for i, state := range []mesos.TaskState{mesos.TaskState_TASK_RUNNING, mesos.TaskState_TASK_FINISHED} {
+		update := fakemaster.UpdateEvent(task, "agent-1", state)
+		send(t, m, update)
+		ack := waitCall(t, m, sched.Call_ACKNOWLEDGE, i+1)[i].GetAcknowledge()
+		if ack.GetTaskId().GetValue() != task || ack.GetAgentId().GetValue() != "agent-1" {
+			t.Errorf("acknowledged task %s on %s", ack.GetTaskId().GetValue(), ack.GetAgentId().GetValue())
+		}
+		if !bytes.Equal(ack.GetUuid(), update.GetUpdate().GetStatus().GetUuid()) {
+			t.Errorf("acknowledged UUID %q, expected %q", ack.GetUuid(), update.GetUpdate().GetStatus().GetUuid())
+		}
+	}
+	inLoop(s, func() {
+		if rec, _ := s.tasks.get(task); rec.State != mesos.TaskState_TASK_FINISHED {
+			t.Errorf("task in state %s", rec.State)
+		}
+	})
+}
+
+func TestRescindAbandonsLaunch(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s := startScheduler(t, m, func(s *scheduler) { s.callWorkers = 1 })
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+
+	// keep the ACCEPT call queued until the offer is rescinded
+	release := make(chan struct{})
+	inLoop(s, func() { s.pool.submit(func() { <-release }) })
+
+	// the offers of one agent are merged into one launch
+	send(t, m, fakemaster.OffersEvent(
+		fakemaster.Offer("offer-1", "agent-1", "agent-1.example.com", 0.1, 64),
+		fakemaster.Offer("offer-2", "agent-1", "agent-1.example.com", 0.1, 64),
+	))
+	eventually(t, s, "the launch is pending", func() bool { return len(s.launches) == 2 })
+	send(t, m, fakemaster.RescindEvent("offer-1"))
+	eventually(t, s, "the launch is abandoned", func() bool { return len(s.launches) == 0 })
+	close(release)
+
+	// the other offer of the launch is declined, nothing is launched
+	decline := waitCall(t, m, sched.Call_DECLINE, 1)[0]
+	if ids := decline.GetDecline().GetOfferIds(); len(ids) != 1 || ids[0].GetValue() != "offer-2" {
+		t.Errorf("declined offers %v", ids)
+	}
+	inLoop(s, func() {
+		if n := s.tasks.active(); n != 0 {
+			t.Errorf("%d tasks active", n)
+		}
+		if len(s.retries) != 2 {
+			t.Errorf("%d tasks queued again, expected 2", len(s.retries))
+		}
+	})
+	if tasks := fakemaster.LaunchedTasks(m.Calls()); len(tasks) != 0 {
+		t.Errorf("launched %d tasks on rescinded offers", len(tasks))
+	}
+
+	// the tasks are launched on the next offer
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-3", "agent-2", 1)))
+	waitCall(t, m, sched.Call_ACCEPT, 1)
+	if tasks := fakemaster.LaunchedTasks(m.Calls()); len(tasks) != 2 {
+		t.Errorf("launched %d tasks, expected 2", len(tasks))
+	}
+}
+
+func TestLeaderLoss(t *testing.T) {
+	m1 := fakemaster.New()
+	defer m1.Close()
+	m2 := fakemaster.New()
+	defer m2.Close()
+	s := startScheduler(t, m1, nil)
+	id := waitCall(t, m1, sched.Call_RECONCILE, 1)[0].GetFrameworkId().GetValue()
+
+	m1.LoseLeadership(m2.Addr())
+
+	// the scheduler follows the redirect and fails over its framework
+	sub := waitCall(t, m2, sched.Call_SUBSCRIBE, 1)[0]
+	if got := sub.GetSubscribe().GetFrameworkInfo().GetId().GetValue(); got != id {
+		t.Errorf("resubscribed with framework ID %q, expected %q", got, id)
+	}
+	waitCall(t, m2, sched.Call_RECONCILE, 1)
+
+	// calls go to the new leader
+	send(t, m2, fakemaster.OffersEvent(portsOffer("offer-1", "agent-1", 1)))
+	waitCall(t, m2, sched.Call_ACCEPT, 1)
+	s.streamMu.Lock()
+	master := s.master
+	s.streamMu.Unlock()
+	if master != m2.Addr() {
+		t.Errorf("subscribed to %s, expected %s", master, m2.Addr())
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// shutdownAction is what happens to the framework when the scheduler
+// shuts down
+type shutdownAction int
+
+const (
+	// shutdownFailover disconnects, the master keeps the tasks running
+	// for the failover timeout so a new scheduler can take over
+	shutdownFailover shutdownAction = iota
+	// shutdownTeardown removes the framework and lets the master kill
+	// its tasks
+	shutdownTeardown
+)
+
+// parseShutdownAction parses failover or teardown
+func parseShutdownAction(s string) (shutdownAction, error) {
+	switch s {
+	case "failover":
+		return shutdownFailover, nil
+	case "teardown":
+		return shutdownTeardown, nil
+	}
+	return shutdownFailover, fmt.Errorf("unknown action %q, expecting failover or teardown", s)
+}
+
+// shutdown drains the scheduler, it is safe to call from any goroutine
+func (s *scheduler) shutdown() {
+	s.do(s.drain)
+}
+
+// drain stops launching tasks and waits up to shutdownWait for the
+// active tasks to end before the framework is disconnected or torn
+// down.
+func (s *scheduler) drain() {
+	if s.draining {
+		return
+	}
+	active := s.tasks.active()
+	log.Println("Shutting down, ", active, " tasks active")
+	s.draining = true
+	s.acceptNew = false
+	s.retries = nil
+	if active == 0 || s.shutdownWait <= 0 {
+		s.finishShutdown()
+		return
+	}
+	log.Println("Waiting up to ", s.shutdownWait, " for tasks to end")
+	time.AfterFunc(s.shutdownWait, func() {
+		s.do(func() {
+			if !s.shutdownDone {
+				log.Println("Tasks did not end within ", s.shutdownWait)
+				s.finishShutdown()
+			}
+		})
+	})
+}
+
+// drained finishes a shutdown waiting for the active tasks once the
+// last one ended
+func (s *scheduler) drained() {
+	if s.draining && !s.shutdownDone && s.tasks.active() == 0 {
+		log.Println("All tasks ended")
+		s.finishShutdown()
+	}
+}
+
+// finishShutdown kills the remaining tasks if requested, applies the
+// shutdown action and stops the scheduler. The calls are sent outside
+// of the event loop which keeps handling events until the stream is
+// closed.
+func (s *scheduler) finishShutdown() {
+	s.shutdownDone = true
+	var kill []taskRecord
+	if s.shutdownKill && s.shutdownAction == shutdownFailover {
+		for _, rec := range s.tasks.list() {
+			if !terminal(rec.State) {
+				kill = append(kill, rec)
+			}
+		}
+	}
+	policy := s.killPolicy()
+	go func() {
+		for _, rec := range kill {
+			log.Println("Killing task ", rec.ID)
+			s.sendKill(rec, policy)
+		}
+		if s.shutdownAction == shutdownTeardown {
+			log.Println("Tearing down framework")
+			if err := s.callClient.Teardown(); err != nil {
+				log.Println("Unable to send Teardown Call: ", err)
+			} else {
+				// the framework ID can not be used again
+				s.do(func() {
+					if err := s.store.SaveFrameworkID(""); err != nil {
+						log.Println("Unable to clear framework ID: ", err)
+					}
+				})
+			}
+		} else {
+			log.Println("Disconnecting for failover")
+		}
+		s.stop()
+	}()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/fakemaster"
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+	"github.com/gogo/protobuf/proto"
+)
+
+// startLaunched starts a scheduler and launches two tasks on one offer
+func startLaunched(t *testing.T, m *fakemaster.Master, setup func(*scheduler)) (*scheduler, []string) {
+	s := startScheduler(t, m, setup)
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-1", "agent-1", 1)))
+	waitCall(t, m, sched.Call_ACCEPT, 1)
+	var ids []string
+	for _, task := range fakemaster.LaunchedTasks(m.Calls()) {
+		ids = append(ids, task.GetTaskId().GetValue())
+	}
+	return s, ids
+}
+
+func waitStopped(t *testing.T, s *scheduler) {
+	t.Helper()
+	select {
+	case <-s.doneChan:
+	case <-time.After(callTimeout):
+		t.Fatal("scheduler did not stop")
+	}
+}
+
+func expectCalls(t *testing.T, m *fakemaster.Master, typ sched.Call_Type, n int) {
+	t.Helper()
+	if calls, _ := m.WaitCall(typ, n+1, 0); len(calls) != n {
+		t.Errorf("sent %d %s calls, expected %d", len(calls), typ, n)
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s, tasks := startLaunched(t, m, func(s *scheduler) {
+		s.shutdownWait = time.Minute
+	})
+
+	// no tasks are launched while draining
+	s.shutdown()
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-2", "agent-1", 1)))
+	waitCall(t, m, sched.Call_DECLINE, 1)
+	expectCalls(t, m, sched.Call_ACCEPT, 1)
+
+	// the scheduler waits for the last active task to end
+	send(t, m, fakemaster.UpdateEvent(tasks[0], "agent-1", mesos.TaskState_TASK_FINISHED))
+	waitCall(t, m, sched.Call_ACKNOWLEDGE, 1)
+	inLoop(s, func() {
+		if s.shutdownDone {
+			t.Error("shutdown finished with an active task")
+		}
+	})
+	send(t, m, fakemaster.UpdateEvent(tasks[1], "agent-1", mesos.TaskState_TASK_FINISHED))
+	waitStopped(t, s)
+	expectCalls(t, m, sched.Call_KILL, 0)
+	expectCalls(t, m, sched.Call_TEARDOWN, 0)
+}
+
+func TestShutdownKill(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s, tasks := startLaunched(t, m, func(s *scheduler) {
+		s.shutdownWait = 50 * time.Millisecond
+		s.shutdownKill = true
+	})
+	send(t, m, fakemaster.UpdateEvent(tasks[0], "agent-1", mesos.TaskState_TASK_FINISHED))
+	waitCall(t, m, sched.Call_ACKNOWLEDGE, 1)
+
+	// the task still active after the wait is killed before stopping
+	s.shutdown()
+	waitStopped(t, s)
+	kills, _ := m.WaitCall(sched.Call_KILL, 2, 0)
+	if len(kills) != 1 {
+		t.Fatalf("sent %d kills, expected 1", len(kills))
+	}
+	kill := kills[0].GetKill()
+	if kill.GetTaskId().GetValue() != tasks[1] || kill.GetAgentId().GetValue() != "agent-1" {
+		t.Errorf("killed %s on %s", kill.GetTaskId().GetValue(), kill.GetAgentId().GetValue())
+	}
+	expectCalls(t, m, sched.Call_TEARDOWN, 0)
+}
+
+func TestShutdownTeardown(t *testing.T) {
+	m := fakemaster.New()
+	defer m.Close()
+	s := startScheduler(t, m, func(s *scheduler) {
+		s.framework.FailoverTimeout = proto.Float64(60)
+		s.shutdownWait = time.Minute
+		s.shutdownKill = true
+		s.shutdownAction = shutdownTeardown
+	})
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+	if st, _ := s.store.Load(); st.FrameworkID == "" {
+		t.Fatal("framework ID not stored")
+	}
+
+	// without active tasks the framework is torn down right away
+	s.shutdown()
+	waitStopped(t, s)
+	expectCalls(t, m, sched.Call_TEARDOWN, 1)
+	expectCalls(t, m, sched.Call_KILL, 0)
+	if st, _ := s.store.Load(); st.FrameworkID != "" {
+		t.Errorf("framework ID %q kept after teardown", st.FrameworkID)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maxJobRuns limits the run history kept in the store
+const maxJobRuns = 100
+
+// jobRun records one run of the job, the first attempts of the tasks
+// launched from the time new work is accepted until maxTasks is reached.
+type jobRun struct {
+	Time  time.Time `json:"time"`
+	Tasks []string  `json:"tasks"`
+}
+
+// schedulerState is the state persisted between scheduler runs, so a
+// restarted scheduler can fail over to its previous framework and
+// continue with its tasks.
+type schedulerState struct {
+	FrameworkID string                `json:"framework_id"`
+	Tasks       map[string]taskRecord `json:"tasks,omitempty"`
+	Runs        []jobRun              `json:"runs,omitempty"`
+}
+
+// lastRun returns the time of the latest job run
+func (st *schedulerState) lastRun() time.Time {
+	if len(st.Runs) == 0 {
+		return time.Time{}
+	}
+	return st.Runs[len(st.Runs)-1].Time
+}
+
+// stateStore persists the scheduler state. Changes may be persisted
+// in the background, Sync tells when they are durable.
+type stateStore interface {
+	Load() (*schedulerState, error)
+	SaveFrameworkID(id string) error
+	SaveTask(rec taskRecord) error
+	DeleteTask(id string) error
+	// SaveRun saves run, replacing a saved run started at the same time
+	SaveRun(run jobRun) error
+	// Sync calls f once all changes so far are durable. f is dropped
+	// when they could not be persisted.
+	Sync(f func())
+	// Close persists the pending changes
+	Close() error
+}
+
+// memStore keeps the state in memory only
+type memStore struct {
+	mu    sync.Mutex
+	state schedulerState
+	// changed is called with mu held after every change
+	changed func()
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		state:   schedulerState{Tasks: make(map[string]taskRecord)},
+		changed: func() {},
+	}
+}
+
+// Load returns a copy of the state
+func (m *memStore) Load() (*schedulerState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := &schedulerState{
+		FrameworkID: m.state.FrameworkID,
+		Tasks:       make(map[string]taskRecord, len(m.state.Tasks)),
+		Runs:        append([]jobRun(nil), m.state.Runs...),
+	}
+	for id, rec := range m.state.Tasks {
+		st.Tasks[id] = rec
+	}
+	return st, nil
+}
+
+func (m *memStore) SaveFrameworkID(id string) error {
+	return m.change(func(st *schedulerState) { st.FrameworkID = id })
+}
+
+func (m *memStore) SaveTask(rec taskRecord) error {
+	return m.change(func(st *schedulerState) { st.Tasks[rec.ID] = rec })
+}
+
+func (m *memStore) DeleteTask(id string) error {
+	return m.change(func(st *schedulerState) { delete(st.Tasks, id) })
+}
+
+func (m *memStore) SaveRun(run jobRun) error {
+	return m.change(func(st *schedulerState) {
+		if n := len(st.Runs); n > 0 && st.Runs[n-1].Time.Equal(run.Time) {
+			st.Runs[n-1] = run
+			return
+		}
+		st.Runs = append(st.Runs, run)
+		if len(st.Runs) > maxJobRuns {
+			st.Runs = st.Runs[len(st.Runs)-maxJobRuns:]
+		}
+	})
+}
+
+// Sync calls f right away, there is nothing to persist
+func (m *memStore) Sync(f func()) {
+	f()
+}
+
+func (m *memStore) Close() error {
+	return nil
+}
+
+func (m *memStore) change(f func(*schedulerState)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f(&m.state)
+	m.changed()
+	return nil
+}
+
+// fileStore keeps the state in a JSON file. A writer goroutine rewrites
+// the file atomically after changes, so the event loop never waits for
+// the disk. Changes made while a write is in progress are written
+// together by the next one.
+type fileStore struct {
+	*memStore
+	path string
+
+	// version counts the changes, written is the version in the file.
+	// waiters are the Sync callbacks by version.
+	version int
+	written int
+	waiters []syncWaiter
+	dirty   chan struct{}
+	closing chan struct{}
+	closed  chan struct{}
+	err     error
+}
+
+type syncWaiter struct {
+	version int
+	f       func()
+}
+
+// openFileStore opens the state file at path and starts its writer.
+// A missing file yields an empty state.
+func openFileStore(path string) (*fileStore, error) {
+	s := &fileStore{
+		memStore: newMemStore(),
+		path:     path,
+		dirty:    make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	s.memStore.changed = s.changed
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.state); err != nil {
+			return nil, err
+		}
+		if s.state.Tasks == nil {
+			s.state.Tasks = make(map[string]taskRecord)
+		}
+	}
+	go s.run()
+	return s, nil
+}
+
+// changed schedules a write, it is called with mu held
+func (s *fileStore) changed() {
+	s.version++
+	select {
+	case s.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// Sync calls f once the file contains all changes so far
+func (s *fileStore) Sync(f func()) {
+	s.mu.Lock()
+	if s.written == s.version {
+		s.mu.Unlock()
+		f()
+		return
+	}
+	s.waiters = append(s.waiters, syncWaiter{version: s.version, f: f})
+	s.mu.Unlock()
+}
+
+// Close writes the pending changes and stops the writer
+func (s *fileStore) Close() error {
+	close(s.closing)
+	<-s.closed
+	return s.err
+}
+
+func (s *fileStore) run() {
+	defer close(s.closed)
+	for {
+		select {
+		case <-s.dirty:
+			s.flush()
+		case <-s.closing:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush writes the current state and runs the waiters it covers. The
+// waiters are dropped if the write fails, the next change retries it.
+func (s *fileStore) flush() {
+	s.mu.Lock()
+	if s.written == s.version {
+		s.mu.Unlock()
+		return
+	}
+	version := s.version
+	data, err := json.Marshal(&s.state)
+	s.mu.Unlock()
+	if err == nil {
+		err = s.write(data)
+	}
+
+	s.mu.Lock()
+	var done []syncWaiter
+	i := 0
+	for ; i < len(s.waiters) && s.waiters[i].version <= version; i++ {
+		done = append(done, s.waiters[i])
+	}
+	s.waiters = s.waiters[i:]
+	if err == nil {
+		s.written = version
+	}
+	s.err = err
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Println("Unable to write state file, dropping ", len(done), " pending calls: ", err)
+		return
+	}
+	for _, w := range done {
+		w.f()
+	}
+}
+
+func (s *fileStore) write(data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// saveFrameworkID persists the framework ID. Without failover timeout
+// the framework ends with the scheduler, so no ID is kept.
+func (s *scheduler) saveFrameworkID() {
+	id := s.callClient.FrameworkID().GetValue()
+	if s.framework.GetFailoverTimeout() <= 0 {
+		id = ""
+	}
+	if err := s.store.SaveFrameworkID(id); err != nil {
+		log.Println("Unable to save framework ID: ", err)
+	}
+}
+
+// restore continues with the tasks and run history of st
+func (s *scheduler) restore(st *schedulerState) {
+	recs := make([]taskRecord, 0, len(st.Tasks))
+	for _, rec := range st.Tasks {
+		recs = append(recs, rec)
+	}
+	s.tasks.restore(recs)
+
+	last := st.lastRun()
+	wait := time.Duration(s.waitTime) * time.Second
+	if since := time.Since(last); since < wait {
+		log.Println("Last run at ", last, ", waiting before launching new tasks")
+		s.acceptNew = false
+		time.AfterFunc(wait-since, func() { s.do(func() { s.acceptWork(time.Now()) }) })
+	}
+}
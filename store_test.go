@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	s, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	s.SaveFrameworkID("framework")
+	s.SaveRun(jobRun{Time: start, Tasks: []string{"1"}})
+	s.SaveTask(taskRecord{ID: "1"})
+
+	// the file contains the changes once Sync calls back
+	synced := make(chan *schedulerState, 1)
+	s.Sync(func() {
+		f, err := openFileStore(path)
+		if err != nil {
+			t.Error(err)
+		}
+		st, _ := f.Load()
+		f.Close()
+		synced <- st
+	})
+	select {
+	case st := <-synced:
+		if st.FrameworkID != "framework" || len(st.Tasks) != 1 || len(st.Runs) != 1 {
+			t.Errorf("state file missing changes: %+v", st)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sync did not call back")
+	}
+
+	// a run started at the same time is replaced
+	s.SaveRun(jobRun{Time: start, Tasks: []string{"1", "2"}})
+	s.SaveRun(jobRun{Time: start.Add(time.Minute), Tasks: []string{"3"}})
+	s.DeleteTask("1")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	st, _ := s.Load()
+	if len(st.Tasks) != 0 {
+		t.Errorf("deleted task restored: %+v", st.Tasks)
+	}
+	if len(st.Runs) != 2 || len(st.Runs[0].Tasks) != 2 || len(st.Runs[1].Tasks) != 1 {
+		t.Errorf("unexpected runs %+v", st.Runs)
+	}
+	if !st.lastRun().Equal(start.Add(time.Minute)) {
+		t.Errorf("last run at %s", st.lastRun())
+	}
+}
+
+func TestFileStoreSyncDroppedOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := openFileStore(filepath.Join(dir, "missing", "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SaveFrameworkID("framework")
+	called := make(chan struct{}, 1)
+	s.Sync(func() { called <- struct{}{} })
+	if err := s.Close(); err == nil {
+		t.Error("expected a write error")
+	}
+	select {
+	case <-called:
+		t.Error("Sync called back for changes not written")
+	default:
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Bounds of the time declined resources are not offered again
+const (
+	minRefuse = time.Second
+	maxRefuse = time.Hour
+)
+
+// updateOffers suppresses offers while no task can be launched and
+// revives them once one can. Only one of the calls is in flight at a
+// time so they reach the master in order.
+func (s *scheduler) updateOffers() {
+	if s.offersCallPending {
+		return
+	}
+	want := s.canLaunch()
+	if want != s.suppressed {
+		return
+	}
+	s.offersCallPending = true
+	s.suppressed = !want
+	s.pool.submit(func() {
+		var err error
+		if want {
+			debugLog(fmt.Sprintln("Reviving offers"))
+			err = s.callClient.Revive()
+		} else {
+			debugLog(fmt.Sprintln("Suppressing offers"))
+			err = s.callClient.Suppress()
+		}
+		s.do(func() {
+			s.offersCallPending = false
+			if err != nil {
+				log.Println("Unable to send Revive or Suppress Call: ", err)
+				s.suppressed = want
+				return
+			}
+			s.updateOffers()
+		})
+	})
+}
+
+// refuseFilters returns filters for declined resources which keep them
+// from being offered again until we may launch the next task. A revive
+// clears them earlier when a retry becomes ready.
+func (s *scheduler) refuseFilters(now time.Time) *mesos.Filters {
+	refuse := s.nextWait.Sub(now)
+	if s.paused || s.draining {
+		refuse = maxRefuse
+	}
+	if refuse < minRefuse {
+		refuse = minRefuse
+	}
+	if refuse > maxRefuse {
+		refuse = maxRefuse
+	}
+	return &mesos.Filters{RefuseSeconds: proto.Float64(refuse.Seconds())}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/fakemaster"
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
+)
+
+// offersCalls returns the SUPPRESS and REVIVE calls in the order received
+func offersCalls(m *fakemaster.Master) []sched.Call_Type {
+	var types []sched.Call_Type
+	for _, call := range m.Calls() {
+		if t := call.GetType(); t == sched.Call_SUPPRESS || t == sched.Call_REVIVE {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+func expectOffersCalls(t *testing.T, s *scheduler, m *fakemaster.Master, want ...sched.Call_Type) {
+	t.Helper()
+	last := want[len(want)-1]
+	n := 0
+	for _, typ := range want {
+		if typ == last {
+			n++
+		}
+	}
+	waitCall(t, m, last, n)
+	eventually(t, s, "no call is pending", func() bool { return !s.offersCallPending })
+	got := offersCalls(m)
+	if len(got) != len(want) {
+		t.Fatalf("sent %v, expected %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sent %v, expected %v", got, want)
+		}
+	}
+}
+
+func declinedFor(t *testing.T, m *fakemaster.Master, n int) float64 {
+	t.Helper()
+	return waitCall(t, m, sched.Call_DECLINE, n)[n-1].GetDecline().GetFilters().GetRefuseSeconds()
+}
+
+func TestSuppressRevive(t *testing.T) {
+	suppress, revive := sched.Call_SUPPRESS, sched.Call_REVIVE
+	m := fakemaster.New()
+	defer m.Close()
+	s := startScheduler(t, m, func(s *scheduler) {
+		s.retry.minBackoff = 20 * time.Millisecond
+		s.retry.maxBackoff = 20 * time.Millisecond
+		s.client.Retry.Attempts = 1
+	})
+	waitCall(t, m, sched.Call_RECONCILE, 1)
+
+	// offers are suppressed at maxTasks, a failed call is sent again
+	m.Fail(suppress, 1)
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-1", "agent-1", 1)))
+	waitCall(t, m, sched.Call_ACCEPT, 1)
+	expectOffersCalls(t, s, m, suppress)
+
+	// offers still sent are declined until the wait time elapses
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-2", "agent-1", 1)))
+	if refuse := declinedFor(t, m, 1); refuse < 50 || refuse > 60 {
+		t.Errorf("declined for %gs, expected up to the wait time of 60s", refuse)
+	}
+
+	// a retry revives offers for the free slot
+	tasks := fakemaster.LaunchedTasks(m.Calls())
+	send(t, m, fakemaster.UpdateEvent(tasks[0].GetTaskId().GetValue(), "agent-1", mesos.TaskState_TASK_FAILED))
+	expectOffersCalls(t, s, m, suppress, revive)
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-3", "agent-1", 1)))
+	waitCall(t, m, sched.Call_ACCEPT, 2)
+	expectOffersCalls(t, s, m, suppress, revive, suppress)
+
+	// a finished task does not revive offers before the wait time elapsed
+	send(t, m, fakemaster.UpdateEvent(tasks[1].GetTaskId().GetValue(), "agent-1", mesos.TaskState_TASK_FINISHED))
+	waitCall(t, m, sched.Call_ACKNOWLEDGE, 2)
+	time.Sleep(50 * time.Millisecond)
+	expectOffersCalls(t, s, m, suppress, revive, suppress)
+	inLoop(s, func() { s.acceptWork(time.Now()) })
+	expectOffersCalls(t, s, m, suppress, revive, suppress, revive)
+
+	// a paused job declines offers for the longest time
+	inLoop(s, func() { s.paused = true })
+	expectOffersCalls(t, s, m, suppress, revive, suppress, revive, suppress)
+	send(t, m, fakemaster.OffersEvent(portsOffer("offer-4", "agent-1", 1)))
+	if refuse := declinedFor(t, m, 2); refuse != maxRefuse.Seconds() {
+		t.Errorf("declined for %gs while paused, expected %gs", refuse, maxRefuse.Seconds())
+	}
+}
+
+func TestRefuseFilters(t *testing.T) {
+	now := time.Now()
+	s := &scheduler{}
+	for _, test := range []struct {
+		nextWait time.Duration
+		paused   bool
+		want     time.Duration
+	}{
+		{30 * time.Second, false, 30 * time.Second},
+		{0, false, minRefuse},
+		{-time.Minute, false, minRefuse},
+		{2 * time.Hour, false, maxRefuse},
+		{30 * time.Second, true, maxRefuse},
+	} {
+		s.nextWait = now.Add(test.nextWait)
+		s.paused = test.paused
+		if got := s.refuseFilters(now).GetRefuseSeconds(); got != test.want.Seconds() {
+			t.Errorf("next wait in %s, paused %v: refuse %gs, expected %gs", test.nextWait, test.paused, got, test.want.Seconds())
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
+)
+
+// maxFinishedTasks limits how many tasks in a terminal state are kept
+const maxFinishedTasks = 100
+
+// taskEvent is a state observed for a task
+type taskEvent struct {
+	Time    time.Time               `json:"time"`
+	State   mesos.TaskState         `json:"state"`
+	Reason  mesos.TaskStatus_Reason `json:"reason,omitempty"`
+	Source  mesos.TaskStatus_Source `json:"source,omitempty"`
+	Message string                  `json:"message,omitempty"`
+}
+
+// taskRecord is what the scheduler knows about one task
+type taskRecord struct {
+	ID        string            `json:"id"`
+	AgentID   string            `json:"agent_id"`
+	OfferID   string            `json:"offer_id,omitempty"`
+	Resources []*mesos.Resource `json:"resources,omitempty"`
+	Launched  time.Time         `json:"launched"`
+	State     mesos.TaskState   `json:"state"`
+	History   []taskEvent       `json:"history"`
+	// Attempt counts the launches of the task, starting with 1
+	Attempt int `json:"attempt,omitempty"`
+	// Outcome describes what the scheduler did about a failed task
+	Outcome string `json:"outcome,omitempty"`
+	// TimedOut is set to runtime or staging when the task was killed
+	// for exceeding its deadline at KillSent
+	TimedOut string    `json:"timed_out,omitempty"`
+	KillSent time.Time `json:"kill_sent,omitempty"`
+	// Hostname and Attributes of the agent for placement constraints
+	Hostname   string             `json:"hostname,omitempty"`
+	Attributes []*mesos.Attribute `json:"attributes,omitempty"`
+}
+
+// runningSince returns when the task started running, zero if it
+// never did.
+func (rec *taskRecord) runningSince() time.Time {
+	for _, ev := range rec.History {
+		if ev.State == mesos.TaskState_TASK_RUNNING {
+			return ev.Time
+		}
+	}
+	return time.Time{}
+}
+
+// attempt returns the attempt of the task, records of older
+// scheduler versions have none.
+func (rec *taskRecord) attempt() int {
+	if rec.Attempt < 1 {
+		return 1
+	}
+	return rec.Attempt
+}
+
+// terminal reports whether state is final for a task
+func terminal(state mesos.TaskState) bool {
+	switch state {
+	case mesos.TaskState_TASK_FINISHED,
+		mesos.TaskState_TASK_FAILED,
+		mesos.TaskState_TASK_KILLED,
+		mesos.TaskState_TASK_LOST,
+		mesos.TaskState_TASK_ERROR:
+		return true
+	}
+	return false
+}
+
+// taskRegistry keeps the records of all tasks by task ID.
+// It is safe for concurrent use.
+type taskRegistry struct {
+	mu    sync.Mutex
+	tasks map[string]*taskRecord
+}
+
+func newTaskRegistry() *taskRegistry {
+	return &taskRegistry{tasks: make(map[string]*taskRecord)}
+}
+
+// restore adds records loaded from a state store
+func (r *taskRegistry) restore(recs []taskRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rec := range recs {
+		rec := rec.copy()
+		r.tasks[rec.ID] = &rec
+	}
+}
+
+// launched records attempt of task as staging on the agent of offer. It
+// returns the new record and the IDs of finished tasks dropped to make room.
+func (r *taskRegistry) launched(task *mesos.TaskInfo, offer *mesos.Offer, attempt int) (taskRecord, []string) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := &taskRecord{
+		ID:         task.GetTaskId().GetValue(),
+		AgentID:    offer.GetAgentId().GetValue(),
+		Hostname:   offer.GetHostname(),
+		OfferID:    offer.GetId().GetValue(),
+		Resources:  task.GetResources(),
+		Attributes: offer.GetAttributes(),
+		Launched:   now,
+		State:      mesos.TaskState_TASK_STAGING,
+		History:    []taskEvent{{Time: now, State: mesos.TaskState_TASK_STAGING}},
+		Attempt:    attempt,
+	}
+	r.tasks[rec.ID] = rec
+	return rec.copy(), r.prune()
+}
+
+// remove forgets a task, e.g. when it could not be launched
+func (r *taskRegistry) remove(id string) {
+	r.mu.Lock()
+	delete(r.tasks, id)
+	r.mu.Unlock()
+}
+
+// update records status and returns a copy of the updated record.
+// Tasks not launched by this scheduler instance, e.g. after a failover,
+// are adopted.
+func (r *taskRegistry) update(status *mesos.TaskStatus) taskRecord {
+	now := time.Now()
+	id := status.GetTaskId().GetValue()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.tasks[id]
+	if !ok {
+		rec = &taskRecord{ID: id, Launched: now}
+		r.tasks[id] = rec
+	}
+	if agent := status.GetAgentId().GetValue(); agent != "" {
+		rec.AgentID = agent
+	}
+	rec.State = status.GetState()
+	rec.History = append(rec.History, taskEvent{
+		Time:    now,
+		State:   status.GetState(),
+		Reason:  status.GetReason(),
+		Source:  status.GetSource(),
+		Message: status.GetMessage(),
+	})
+	return rec.copy()
+}
+
+// setOutcome records the outcome of task id and returns a copy of its record
+func (r *taskRegistry) setOutcome(id, outcome string) taskRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.tasks[id]
+	if !ok {
+		return taskRecord{}
+	}
+	rec.Outcome = outcome
+	return rec.copy()
+}
+
+// timedOut marks task id as timed out for reason and killed at now
+func (r *taskRegistry) timedOut(id, reason string, now time.Time) taskRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.tasks[id]
+	if !ok {
+		return taskRecord{}
+	}
+	rec.TimedOut = reason
+	rec.KillSent = now
+	return rec.copy()
+}
+
+// get returns a copy of the record of task id
+func (r *taskRegistry) get(id string) (taskRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.tasks[id]
+	if !ok {
+		return taskRecord{}, false
+	}
+	return rec.copy(), true
+}
+
+// list returns copies of all records ordered by launch time
+func (r *taskRegistry) list() []taskRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recs := make([]taskRecord, 0, len(r.tasks))
+	for _, rec := range r.tasks {
+		recs = append(recs, rec.copy())
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].Launched.Before(recs[j].Launched) })
+	return recs
+}
+
+// active returns the number of tasks not in a terminal state
+func (r *taskRegistry) active() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, rec := range r.tasks {
+		if !terminal(rec.State) {
+			n++
+		}
+	}
+	return n
+}
+
+// prune drops the oldest terminal tasks beyond maxFinishedTasks and
+// returns their IDs, r.mu must be held.
+func (r *taskRegistry) prune() []string {
+	var finished []*taskRecord
+	for _, rec := range r.tasks {
+		if terminal(rec.State) {
+			finished = append(finished, rec)
+		}
+	}
+	if len(finished) <= maxFinishedTasks {
+		return nil
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Launched.Before(finished[j].Launched) })
+	var pruned []string
+	for _, rec := range finished[:len(finished)-maxFinishedTasks] {
+		delete(r.tasks, rec.ID)
+		pruned = append(pruned, rec.ID)
+	}
+	return pruned
+}
+
+func (rec *taskRecord) copy() taskRecord {
+	c := *rec
+	c.History = append([]taskEvent(nil), rec.History...)
+	return c
+}
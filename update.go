@@ -2,56 +2,51 @@ package main
 
 import (
 	"log"
-	"net/http"
 
 	"github.com/bogue1979/mesos-http-scheduler/mesos/mesos"
-	sched "github.com/bogue1979/mesos-http-scheduler/mesos/sched"
 )
 
 func (s *scheduler) status(status *mesos.TaskStatus) {
+	prev, known := s.tasks.get(status.GetTaskId().GetValue())
+	rec := s.tasks.update(status)
+	s.reconciled(status)
 
-	if status.GetState() == mesos.TaskState_TASK_LOST ||
-		status.GetState() == mesos.TaskState_TASK_KILLED ||
-		status.GetState() == mesos.TaskState_TASK_FAILED {
-		log.Fatal(
-			"Exiting because task ",
-			status.GetTaskId().GetValue(),
-			" is in unexpected statse ", status.GetState().String(),
-			" with reason ", status.GetReason().String(),
-			" from source ", status.GetSource().String(),
-			" with message ", status.GetMessage(),
-		)
+	// apply the retry policy once when a task fails
+	var next func()
+	if failed(status.GetState()) && !(known && terminal(prev.State)) {
+		if invalidOffer(status) {
+			rec, next = s.relaunch(rec)
+		} else {
+			rec, next = s.taskFailed(rec, status)
+		}
+	}
+
+	// persist before acknowledging, the master resends unacknowledged updates
+	if err := s.store.SaveTask(rec); err != nil {
+		log.Println("Unable to save task ", rec.ID, ", not acknowledging update: ", err)
+		return
+	}
+	if next != nil {
+		defer next()
 	}
 
 	if status.GetState() == mesos.TaskState_TASK_RUNNING {
 		log.Printf("Task with ID %s in state RUNNING", status.GetTaskId().GetValue())
 	}
 
-	// send ack
+	// send ack once the update is durable
 	if status.GetUuid() != nil {
-		call := &sched.Call{
-			FrameworkId: s.framework.GetId(),
-			Type:        sched.Call_ACKNOWLEDGE.Enum(),
-			Acknowledge: &sched.Call_Acknowledge{
-				AgentId: status.GetAgentId(),
-				TaskId:  status.GetTaskId(),
-				Uuid:    status.GetUuid(),
-			},
-		}
-
-		// send call
-		resp, err := s.send(call)
-		if err != nil {
-			log.Println("Unable to send Acknowledge Call: ", err)
-			return
-		}
-		if resp.StatusCode != http.StatusAccepted {
-			log.Printf("Acknowledge call returned unexpected status: %d", resp.StatusCode)
-		}
+		s.store.Sync(func() {
+			s.pool.submit(func() {
+				err := s.callClient.Acknowledge(status.GetAgentId(), status.GetTaskId(), status.GetUuid())
+				if err != nil {
+					log.Println("Unable to send Acknowledge Call: ", err)
+				}
+			})
+		})
 	}
 
 	if status.GetState() == mesos.TaskState_TASK_ERROR {
-		s.taskLaunched--
 		log.Println(
 			"Task ID ", status.TaskId.GetValue(),
 			" state = ", status.GetState().String(),
@@ -61,6 +56,9 @@ func (s *scheduler) status(status *mesos.TaskStatus) {
 
 	if status.GetState() == mesos.TaskState_TASK_FINISHED {
 		log.Println("Finished task: ", status.GetTaskId().GetValue())
-		s.taskLaunched--
+	}
+
+	if terminal(status.GetState()) {
+		s.drained()
 	}
 }